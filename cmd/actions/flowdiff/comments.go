@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stripApexComments removes // and /* */ comments from Apex source while
+// leaving string literals untouched. Lines that held only comments are
+// dropped so that comment-only edits produce no diff; other lines keep their
+// code with trailing whitespace trimmed.
+func stripApexComments(src string) string {
+	var out strings.Builder
+	var line strings.Builder
+	hadComment := false
+	inBlock := false
+	flushLine := func() {
+		text := strings.TrimRight(line.String(), " \t\r")
+		if !hadComment || strings.TrimSpace(text) != "" {
+			out.WriteString(text)
+			out.WriteByte('\n')
+		}
+		line.Reset()
+		hadComment = false
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			flushLine()
+			i++
+		case inBlock:
+			hadComment = true
+			if strings.HasPrefix(src[i:], "*/") {
+				inBlock = false
+				i += 2
+			} else {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "//"):
+			hadComment = true
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			hadComment = true
+			inBlock = true
+			i += 2
+		case c == '\'':
+			// Apex string literals can't span lines, so an unterminated
+			// literal ends at the newline.
+			end := i + 1
+			for end < len(src) && src[end] != '\n' {
+				if src[end] == '\\' && end+1 < len(src) && src[end+1] != '\n' {
+					end += 2
+					continue
+				}
+				end++
+				if src[end-1] == '\'' {
+					break
+				}
+			}
+			line.WriteString(src[i:end])
+			i = end
+		default:
+			line.WriteByte(c)
+			i++
+		}
+	}
+	if line.Len() > 0 || hadComment {
+		flushLine()
+		if !strings.HasSuffix(src, "\n") {
+			return strings.TrimSuffix(out.String(), "\n")
+		}
+	}
+	return out.String()
+}
+
+// stripApexCommentsInDir rewrites every generated Apex file under dir
+// without comments, including the generated.apex stdout fallback.
+func stripApexCommentsInDir(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".cls", ".trigger", ".apex":
+		default:
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		if err := os.WriteFile(path, []byte(stripApexComments(string(data))), 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", path, err)
+		}
+		return nil
+	})
+}
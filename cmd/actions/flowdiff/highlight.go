@@ -0,0 +1,101 @@
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+// apexKeywords lists reserved words and common SOQL keywords highlighted in the
+// side-by-side report. Apex is case-insensitive, so lookups use lower case.
+var apexKeywords = map[string]bool{
+	"abstract": true, "after": true, "and": true, "before": true, "break": true,
+	"by": true, "catch": true, "class": true, "continue": true, "delete": true,
+	"do": true, "else": true, "enum": true, "extends": true, "false": true,
+	"final": true, "finally": true, "for": true, "from": true, "get": true,
+	"global": true, "if": true, "implements": true, "inherited": true, "insert": true,
+	"instanceof": true, "interface": true, "limit": true, "merge": true, "new": true,
+	"not": true, "null": true, "on": true, "or": true, "order": true,
+	"override": true, "private": true, "protected": true, "public": true, "return": true,
+	"select": true, "set": true, "sharing": true, "static": true, "super": true,
+	"switch": true, "testmethod": true, "this": true, "throw": true, "transient": true,
+	"trigger": true, "true": true, "try": true, "undelete": true, "update": true,
+	"upsert": true, "virtual": true, "void": true, "webservice": true, "when": true,
+	"where": true, "while": true, "with": true, "without": true,
+}
+
+// highlightApex escapes text for HTML and wraps Apex keywords, string literals,
+// and comments in spans. Tokenizing is line-local: a block comment or string
+// that is not closed on the line is highlighted through the end of the text.
+func highlightApex(text string) string {
+	var out strings.Builder
+	plainStart := 0
+	flushPlain := func(end int) {
+		if end > plainStart {
+			out.WriteString(html.EscapeString(text[plainStart:end]))
+		}
+	}
+	wrap := func(class string, start, end int) {
+		flushPlain(start)
+		out.WriteString("<span class=\"" + class + "\">")
+		out.WriteString(html.EscapeString(text[start:end]))
+		out.WriteString("</span>")
+		plainStart = end
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case strings.HasPrefix(text[i:], "//"):
+			wrap("com", i, len(text))
+			i = len(text)
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				end = len(text)
+			} else {
+				end = i + 2 + end + 2
+			}
+			wrap("com", i, end)
+			i = end
+		case c == '\'':
+			end := i + 1
+			for end < len(text) {
+				if text[end] == '\\' {
+					end += 2
+					continue
+				}
+				if text[end] == '\'' {
+					end++
+					break
+				}
+				end++
+			}
+			if end > len(text) {
+				end = len(text)
+			}
+			wrap("str", i, end)
+			i = end
+		case isIdentStart(c):
+			end := i + 1
+			for end < len(text) && isIdentPart(text[end]) {
+				end++
+			}
+			if apexKeywords[strings.ToLower(text[i:end])] {
+				wrap("kw", i, end)
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	flushPlain(len(text))
+	return out.String()
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// flowIgnoreFile is read from the workspace root to skip flows that should
+// never be diffed, such as generated or vendored metadata.
+const flowIgnoreFile = ".flow2apexignore"
+
+// ignorePattern is one compiled gitignore-style pattern.
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// flowIgnore matches repository-relative paths against gitignore-style
+// patterns. As with gitignore, the last matching pattern wins and a path is
+// ignored when any of its parent directories is ignored.
+type flowIgnore struct {
+	patterns []ignorePattern
+}
+
+// loadFlowIgnore reads .flow2apexignore from workspace, if present, and
+// appends globs passed on the command line.
+func loadFlowIgnore(workspace string, globs []string) (*flowIgnore, error) {
+	ignore := &flowIgnore{}
+	f, err := os.Open(filepath.Join(workspace, flowIgnoreFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("open %s: %w", flowIgnoreFile, err)
+	}
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if err := ignore.add(scanner.Text()); err != nil {
+				return nil, fmt.Errorf("parse %s: %w", flowIgnoreFile, err)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read %s: %w", flowIgnoreFile, err)
+		}
+	}
+	for _, glob := range globs {
+		if err := ignore.add(glob); err != nil {
+			return nil, fmt.Errorf("parse ignore-glob: %w", err)
+		}
+	}
+	return ignore, nil
+}
+
+func (ig *flowIgnore) add(line string) error {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	var pattern ignorePattern
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	re, err := regexp.Compile(ignoreGlobRegexp(line, anchored))
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", line, err)
+	}
+	pattern.re = re
+	ig.patterns = append(ig.patterns, pattern)
+	return nil
+}
+
+// ignoreGlobRegexp translates a gitignore glob into an anchored regular
+// expression. Unanchored patterns match at any directory depth.
+func ignoreGlobRegexp(glob string, anchored bool) string {
+	var out strings.Builder
+	if anchored {
+		out.WriteString("^")
+	} else {
+		out.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			out.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**") && i+2 == len(glob) && (i == 0 || glob[i-1] == '/'):
+			out.WriteString(".*")
+			i++
+		case c == '*':
+			out.WriteString("[^/]*")
+		case c == '?':
+			out.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				out.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			out.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			out.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			out.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	out.WriteString("$")
+	return out.String()
+}
+
+// Ignored reports whether the repository-relative path is excluded.
+func (ig *flowIgnore) Ignored(path string) bool {
+	if ig == nil || len(ig.patterns) == 0 {
+		return false
+	}
+	parts := strings.Split(strings.Trim(filepath.ToSlash(path), "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		if ig.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return ig.match(strings.Join(parts, "/"), false)
+}
+
+func (ig *flowIgnore) match(path string, isDir bool) bool {
+	ignored := false
+	for _, pattern := range ig.patterns {
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+		if pattern.re.MatchString(path) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
+
+// filterIgnoredFlows returns the flows that are not excluded by ignore.
+func filterIgnoredFlows(flows []string, ignore *flowIgnore) []string {
+	out := make([]string, 0, len(flows))
+	for _, flowPath := range flows {
+		if !ignore.Ignored(flowPath) {
+			out = append(out, flowPath)
+		}
+	}
+	return out
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// envList splits a newline-separated environment variable into values,
+// dropping blank lines.
+func envList(name string) stringList {
+	var values stringList
+	for _, line := range strings.Split(os.Getenv(name), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, line)
+		}
+	}
+	return values
+}
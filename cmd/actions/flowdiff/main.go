@@ -1,16 +1,29 @@
 package main
 
 import (
+	"archive/tar"
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/octoberswimmer/flow2apex/actions/internal/sidebyside"
 )
 
 const (
@@ -20,18 +33,66 @@ const (
 	sideBySideWidth   = 200
 	sideBySideTabSize = 3
 
+	// minSideBySideWidth keeps both columns wide enough for diff to place a
+	// separator marker between them.
+	minSideBySideWidth = 20
+	// sideBySideMinScale is the smallest HTML scale applied at the default
+	// width; wider output is allowed to shrink proportionally further.
+	sideBySideMinScale = 0.90
+
 	diffFormatUnified    = "unified"
 	diffFormatSideBySide = "side-by-side"
+
+	// Code fence languages for unified diffs in the comment. GitHub colors
+	// "diff" fences; "text" suits renderers that don't.
+	commentFenceDiff = "diff"
+	commentFenceText = "text"
+
+	// flow2apexExitUnsupported is the converter exit code for a conversion
+	// that succeeded but emitted TODO placeholders for unsupported elements.
+	flow2apexExitUnsupported = 3
+)
+
+// Render statuses reported by renderFlow.
+const (
+	renderOK = iota
+	renderFailed
+	renderMissing
+	renderUnsupported
+	renderTimedOut
+	// renderSkipped marks an --against-org base that was not looked up
+	// because the head render left nothing to compare it with.
+	renderSkipped
+)
+
+// defaultRenderTimeout bounds each flow2apex invocation so a single flow that
+// hangs the converter can't stall the whole job.
+const defaultRenderTimeout = 60 * time.Second
+
+// Process exit codes. Without --exit-code, success always exits 0.
+const (
+	exitNoChanges = 0
+	exitFailure   = 1
+	exitChanges   = 2
 )
 
+// warnOut receives non-fatal warnings; --quiet discards them.
+var warnOut io.Writer = os.Stderr
+
+func warnf(format string, args ...any) {
+	fmt.Fprintf(warnOut, "warning: "+format+"\n", args...)
+}
+
 func main() {
-	if err := run(); err != nil {
+	code, err := run()
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitFailure)
 	}
+	os.Exit(code)
 }
 
-func run() error {
+func run() (int, error) {
 	var baseSHA string
 	var headSHA string
 	var workspace string
@@ -40,6 +101,26 @@ func run() error {
 	var htmlFile string
 	var flow2apexBin string
 	var diffFormat string
+	var commentFence string
+	var width string
+	var nativeDiff bool
+	var flatDiff bool
+	var ignoreComments bool
+	var highlight bool
+	var dryRun bool
+	var summaryFile string
+	var perFlowDir string
+	var againstOrg string
+	var changedOnly bool
+	var exitCode bool
+	var quiet bool
+	var maxFlows string
+	var baseFlowDir string
+	var headFlowDir string
+	var noScale bool
+	var renderTimeout string
+	var runTimeout string
+	ignoreGlobs := envList("IGNORE_GLOBS")
 
 	flag.StringVar(&baseSHA, "base-sha", os.Getenv("BASE_SHA"), "base commit sha")
 	flag.StringVar(&headSHA, "head-sha", os.Getenv("HEAD_SHA"), "head commit sha")
@@ -47,17 +128,57 @@ func run() error {
 	flag.StringVar(&outputFile, "output-file", os.Getenv("GITHUB_OUTPUT"), "step output file path")
 	flag.StringVar(&commentFile, "comment-file", "", "comment markdown output path")
 	flag.StringVar(&htmlFile, "html-file", "", "side-by-side html output path")
+	flag.StringVar(&summaryFile, "summary-file", "", "json summary output path")
+	flag.StringVar(&perFlowDir, "per-flow-dir", os.Getenv("PER_FLOW_DIR"), "directory, relative to workspace unless absolute, for one diff file (and html file in side-by-side mode) per changed flow")
 	flag.StringVar(&flow2apexBin, "flow2apex-bin", os.Getenv("FLOW2APEX_BIN"), "path to flow2apex binary")
 	flag.StringVar(&diffFormat, "diff-format", os.Getenv("DIFF_FORMAT"), "diff format: unified or side-by-side")
+	flag.StringVar(&commentFence, "comment-fence", os.Getenv("COMMENT_FENCE"), fmt.Sprintf("code fence language for unified diffs in the comment: %s or %s (default %s)", commentFenceDiff, commentFenceText, commentFenceDiff))
+	flag.StringVar(&width, "width", os.Getenv("SIDE_BY_SIDE_WIDTH"), fmt.Sprintf("side-by-side output width in columns (default %d)", sideBySideWidth))
+	flag.BoolVar(&nativeDiff, "native-diff", envBool("NATIVE_DIFF"), "render side-by-side diffs with the built-in differ instead of system diff")
+	flag.BoolVar(&highlight, "highlight", envBool("HIGHLIGHT"), "highlight Apex keywords, strings, and comments in the side-by-side html report")
+	flag.BoolVar(&flatDiff, "flat-diff", envBool("FLAT_DIFF"), "render each flow's unified diff as one block instead of one block per generated file")
+	flag.BoolVar(&ignoreComments, "ignore-comments", envBool("IGNORE_COMMENTS"), "strip Apex comments from both renders before diffing so comment-only changes are not reported")
+	flag.Var(&ignoreGlobs, "ignore-glob", "gitignore-style pattern for flows to skip (repeatable; adds to "+flowIgnoreFile+")")
+	flag.StringVar(&renderTimeout, "render-timeout", os.Getenv("RENDER_TIMEOUT"), fmt.Sprintf("maximum time for each flow2apex invocation, as a duration or seconds; 0 disables (default %s)", defaultRenderTimeout))
+	flag.StringVar(&runTimeout, "timeout", os.Getenv("RUN_TIMEOUT"), "maximum time for the whole run, as a duration or seconds; worktrees and temp files are cleaned up when it expires (default unlimited)")
+	flag.BoolVar(&noScale, "no-scale", envBool("NO_SCALE"), "omit the auto-fit script from the side-by-side html report so wide diffs always scroll")
+	flag.StringVar(&baseFlowDir, "base-dir", "", "directory of base flow files to compare instead of base-sha (requires head-dir)")
+	flag.StringVar(&headFlowDir, "head-dir", "", "directory of head flow files to compare instead of head-sha (requires base-dir)")
+	flag.StringVar(&maxFlows, "max-flows", os.Getenv("MAX_FLOWS"), "maximum number of changed flows to render; 0 renders all")
+	flag.BoolVar(&changedOnly, "changed-only", envBool("CHANGED_ONLY"), "omit flows whose generated Apex did not change from the comment and html report")
+	flag.StringVar(&againstOrg, "against-org", os.Getenv("AGAINST_ORG"), "sf org alias whose deployed Apex replaces the base render (base-sha and base-dir are then optional)")
+	flag.BoolVar(&dryRun, "dry-run", envBool("DRY_RUN"), "print detected flows and planned commands without creating worktrees or writing files")
+	flag.BoolVar(&exitCode, "exit-code", envBool("EXIT_CODE"), fmt.Sprintf("exit %d when some flow's generated Apex differs, %d when none does, and %d on failure, including renders that could not be diffed", exitChanges, exitNoChanges, exitFailure))
+	flag.BoolVar(&quiet, "quiet", envBool("QUIET"), "suppress warnings on stderr")
 	flag.Parse()
 
-	if baseSHA == "" || headSHA == "" {
-		return fmt.Errorf("base-sha and head-sha are required")
+	if quiet {
+		warnOut = io.Discard
+	}
+	dirMode := baseFlowDir != "" || headFlowDir != ""
+	if dirMode {
+		if headFlowDir == "" || (baseFlowDir == "" && againstOrg == "") {
+			return exitFailure, fmt.Errorf("base-dir and head-dir must be set together")
+		}
+		// The converter runs with the flow directory as its working directory,
+		// so relative flow paths must be resolved up front.
+		for _, dir := range []*string{&baseFlowDir, &headFlowDir} {
+			if *dir == "" {
+				continue
+			}
+			abs, err := filepath.Abs(*dir)
+			if err != nil {
+				return exitFailure, fmt.Errorf("resolve %s: %w", *dir, err)
+			}
+			*dir = abs
+		}
+	} else if headSHA == "" || (baseSHA == "" && againstOrg == "") {
+		return exitFailure, fmt.Errorf("base-sha and head-sha are required")
 	}
 	if workspace == "" {
 		cwd, err := os.Getwd()
 		if err != nil {
-			return fmt.Errorf("get cwd: %w", err)
+			return exitFailure, fmt.Errorf("get cwd: %w", err)
 		}
 		workspace = cwd
 	}
@@ -70,9 +191,52 @@ func run() error {
 	if htmlFile == "" {
 		htmlFile = filepath.Join(workspace, ".github", "flow2apex-pr-diff.html")
 	}
+	if summaryFile == "" {
+		summaryFile = filepath.Join(workspace, ".github", "flow2apex-summary.json")
+	}
+	if perFlowDir != "" && !filepath.IsAbs(perFlowDir) {
+		perFlowDir = filepath.Join(workspace, perFlowDir)
+	}
 	resolvedDiffFormat, err := normalizeDiffFormat(diffFormat)
 	if err != nil {
-		return err
+		return exitFailure, err
+	}
+	resolvedWidth, err := normalizeSideBySideWidth(width)
+	if err != nil {
+		return exitFailure, err
+	}
+	resolvedCommentFence, err := normalizeCommentFence(commentFence)
+	if err != nil {
+		return exitFailure, err
+	}
+	resolvedRenderTimeout, err := normalizeRenderTimeout(renderTimeout)
+	if err != nil {
+		return exitFailure, err
+	}
+	resolvedMaxFlows, err := normalizeMaxFlows(maxFlows)
+	if err != nil {
+		return exitFailure, err
+	}
+	resolvedRunTimeout, err := parseTimeout("timeout", runTimeout, 0)
+	if err != nil {
+		return exitFailure, err
+	}
+
+	// Cancellation, whether from the runner or --timeout, kills running git,
+	// diff, and converter processes and returns an error, so the deferred
+	// worktree and temp dir cleanups below still run.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if resolvedRunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, resolvedRunTimeout)
+		defer cancel()
+	}
+
+	diffOpts := diffOptions{
+		format: resolvedDiffFormat,
+		width:  resolvedWidth,
+		native: nativeDiff,
 	}
 
 	htmlFileOutput := ""
@@ -80,193 +244,457 @@ func run() error {
 		htmlFileOutput = htmlFile
 	}
 
+	// In directory mode the flow directories stand in for the checkouts and
+	// their paths label the report instead of commit shas.
+	baseLabel, headLabel := baseSHA, headSHA
+	baseCheckout, headCheckout := filepath.Join("<tmp>", "base-checkout"), filepath.Join("<tmp>", "head-checkout")
+	var flows []string
+	switch {
+	case dirMode && baseFlowDir == "":
+		// Against an org without a base directory, every head flow is compared.
+		headLabel = headFlowDir
+		headCheckout = headFlowDir
+		flows, err = listFlowFiles(headFlowDir)
+	case dirMode:
+		baseLabel, headLabel = baseFlowDir, headFlowDir
+		baseCheckout, headCheckout = baseFlowDir, headFlowDir
+		flows, err = detectChangedFlowsInDirs(baseFlowDir, headFlowDir)
+	case baseSHA == "":
+		flows, err = listFlowFilesAtSHA(ctx, workspace, headSHA)
+	default:
+		flows, err = detectChangedFlows(ctx, workspace, baseSHA, headSHA)
+	}
+	if err != nil {
+		return exitFailure, runError(ctx, err)
+	}
+	if againstOrg != "" {
+		baseLabel = "org " + againstOrg
+	}
+	ignore, err := loadFlowIgnore(workspace, ignoreGlobs)
+	if err != nil {
+		return exitFailure, err
+	}
+	flows = repoRelativeFlowPaths(flows, workspace, baseFlowDir, headFlowDir)
+	flows = coalesceFlowPairs(filterIgnoredFlows(flows, ignore))
+	flows, omittedFlows := limitFlows(flows, resolvedMaxFlows)
+	if dryRun {
+		resolvedBin, binErr := resolveFlow2ApexBin(flow2apexBin)
+		writeDryRunPlan(os.Stdout, workspace, flows, baseCheckout, headCheckout, againstOrg, resolvedBin, binErr, diffOpts)
+		if omittedFlows > 0 {
+			fmt.Fprintf(os.Stdout, "\n%s\n", omittedFlowsNote(omittedFlows, resolvedMaxFlows))
+		}
+		// Nothing is rendered in a dry run, so report whether any flow
+		// would be diffed.
+		if exitCode && len(flows) > 0 {
+			return exitChanges, nil
+		}
+		return exitNoChanges, nil
+	}
+
 	if err := os.MkdirAll(filepath.Dir(commentFile), 0o755); err != nil {
-		return fmt.Errorf("create comment directory: %w", err)
+		return exitFailure, fmt.Errorf("create comment directory: %w", err)
 	}
 	if err := os.MkdirAll(filepath.Dir(htmlFile), 0o755); err != nil {
-		return fmt.Errorf("create html directory: %w", err)
+		return exitFailure, fmt.Errorf("create html directory: %w", err)
 	}
-
-	flows, err := detectChangedFlows(workspace, baseSHA, headSHA)
-	if err != nil {
-		return err
+	if err := os.MkdirAll(filepath.Dir(summaryFile), 0o755); err != nil {
+		return exitFailure, fmt.Errorf("create summary directory: %w", err)
+	}
+	if perFlowDir != "" {
+		if err := os.MkdirAll(perFlowDir, 0o755); err != nil {
+			return exitFailure, fmt.Errorf("create per-flow directory: %w", err)
+		}
 	}
+	summary := runSummary{TotalFlows: len(flows) + omittedFlows, OmittedFlows: omittedFlows}
 	if len(flows) == 0 {
 		if err := os.WriteFile(commentFile, []byte{}, 0o644); err != nil {
-			return fmt.Errorf("write empty comment file: %w", err)
+			return exitFailure, fmt.Errorf("write empty comment file: %w", err)
 		}
-		return appendOutputs(outputFile, []outputKV{
+		if err := writeSummary(summaryFile, summary); err != nil {
+			return exitFailure, err
+		}
+		return exitNoChanges, appendOutputs(outputFile, []outputKV{
 			{Key: "has_flow_changes", Value: "false"},
 			{Key: "comment_file", Value: commentFile},
 			{Key: "html_file", Value: htmlFileOutput},
+			{Key: "summary_file", Value: summaryFile},
+			{Key: "per_flow_dir", Value: perFlowDir},
 		})
 	}
 
 	flow2apexBin, err = resolveFlow2ApexBin(flow2apexBin)
 	if err != nil {
-		return err
+		return exitFailure, err
 	}
 
 	tmpDir, err := os.MkdirTemp("", "flow2apex-diff-*")
 	if err != nil {
-		return fmt.Errorf("create temp dir: %w", err)
+		return exitFailure, fmt.Errorf("create temp dir: %w", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	baseCheckout := filepath.Join(tmpDir, "base-checkout")
-	if err := createDetachedWorktree(workspace, baseSHA, baseCheckout); err != nil {
-		return err
-	}
-	defer func() {
-		if err := removeWorktree(workspace, baseCheckout); err != nil {
-			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	if !dirMode {
+		sparse := gitSupportsSparseCheckout(workspace)
+
+		if againstOrg == "" {
+			baseCheckout = filepath.Join(tmpDir, "base-checkout")
+			cleanupBase, err := prepareCheckout(ctx, workspace, baseSHA, baseCheckout, flows, sparse)
+			if err != nil {
+				return exitFailure, runError(ctx, err)
+			}
+			defer func() {
+				if err := cleanupBase(); err != nil {
+					warnf("%v", err)
+				}
+			}()
 		}
-	}()
 
-	headCheckout := filepath.Join(tmpDir, "head-checkout")
-	if err := createDetachedWorktree(workspace, headSHA, headCheckout); err != nil {
-		return err
-	}
-	defer func() {
-		if err := removeWorktree(workspace, headCheckout); err != nil {
-			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		headCheckout = filepath.Join(tmpDir, "head-checkout")
+		cleanupHead, err := prepareCheckout(ctx, workspace, headSHA, headCheckout, flows, sparse)
+		if err != nil {
+			return exitFailure, runError(ctx, err)
 		}
-	}()
+		defer func() {
+			if err := cleanupHead(); err != nil {
+				warnf("%v", err)
+			}
+		}()
+	}
 
-	var comment strings.Builder
+	// The comment and html report are streamed to disk flow by flow so large
+	// PRs don't hold every diff in memory at once.
+	comment, err := createStreamWriter(commentFile, maxCommentChars)
+	if err != nil {
+		return exitFailure, fmt.Errorf("create comment file: %w", err)
+	}
+	defer comment.Close()
 	comment.WriteString(diffCommentMarker(resolvedDiffFormat))
 	comment.WriteString("\n")
 	comment.WriteString("## flow2apex Flow Diffs\n\n")
-	comment.WriteString(fmt.Sprintf("Compared generated Apex between base `%s` and head `%s` for changed flow files.\n\n", baseSHA, headSHA))
+	comment.WriteString(fmt.Sprintf("Compared generated Apex between base `%s` and head `%s` for changed flow files.\n\n", baseLabel, headLabel))
 	comment.WriteString(fmt.Sprintf("Diff format: `%s`.\n\n", resolvedDiffFormat))
+	if omittedFlows > 0 {
+		comment.WriteString(omittedFlowsNote(omittedFlows, resolvedMaxFlows) + "\n\n")
+	}
 
-	var sideBySideHTML strings.Builder
+	// The report's table of contents needs every flow's status, so it is
+	// written after the flow sections and moved above them with CSS.
+	var sideBySideHTML *streamWriter
+	var perFlowHTMLHeader string
 	if resolvedDiffFormat == diffFormatSideBySide {
-		sideBySideHTML.WriteString(startSideBySideHTMLReport(baseSHA, headSHA))
+		sideBySideHTML, err = createStreamWriter(htmlFile, 0)
+		if err != nil {
+			return exitFailure, fmt.Errorf("create html file: %w", err)
+		}
+		defer sideBySideHTML.Close()
+		perFlowHTMLHeader = startSideBySideHTMLReport(baseLabel, headLabel, resolvedWidth, !noScale)
+		sideBySideHTML.WriteString(perFlowHTMLHeader)
+		sideBySideHTML.WriteString(sideBySideHTMLOmittedNote(omittedFlows, resolvedMaxFlows))
+		sideBySideHTML.WriteString(sideBySideHTMLMainStart)
 	}
 
+	fr := &flowRun{
+		workspace:      workspace,
+		tmpDir:         tmpDir,
+		baseCheckout:   baseCheckout,
+		headCheckout:   headCheckout,
+		flow2apexBin:   flow2apexBin,
+		againstOrg:     againstOrg,
+		renderTimeout:  resolvedRenderTimeout,
+		ignoreComments: ignoreComments,
+		changedOnly:    changedOnly,
+		diffOpts:       diffOpts,
+		fence:          resolvedCommentFence,
+		flat:           flatDiff,
+		highlight:      highlight,
+		comment:        comment,
+		html:           sideBySideHTML,
+		perFlowDir:     perFlowDir,
+		perFlowHeader:  perFlowHTMLHeader,
+	}
+	var reportEntries []reportEntry
+	shownFlows := 0
 	for _, flowPath := range flows {
-		safe := sanitizeFlowPath(flowPath)
-		baseDir := filepath.Join(tmpDir, "base-render-"+safe)
-		headDir := filepath.Join(tmpDir, "head-render-"+safe)
-		if err := os.MkdirAll(baseDir, 0o755); err != nil {
-			return fmt.Errorf("create base render dir: %w", err)
+		if err := ctx.Err(); err != nil {
+			return exitFailure, runError(ctx, err)
 		}
-		if err := os.MkdirAll(headDir, 0o755); err != nil {
-			return fmt.Errorf("create head render dir: %w", err)
-		}
-
-		baseStatus, baseLog, err := renderFlow(baseCheckout, flow2apexBin, flowPath, baseDir)
+		result, err := fr.diffFlow(ctx, flowPath)
 		if err != nil {
-			return err
+			return exitFailure, runError(ctx, err)
 		}
-		headStatus, headLog, err := renderFlow(headCheckout, flow2apexBin, flowPath, headDir)
-		if err != nil {
-			return err
+		reportStatus := summary.add(result)
+		if !result.shown {
+			continue
 		}
+		if sideBySideHTML != nil {
+			reportEntries = append(reportEntries, reportEntry{FlowPath: flowPath, Status: reportStatus})
+		}
+		shownFlows++
+	}
 
-		comment.WriteString(fmt.Sprintf("### `%s`\n\n", flowPath))
-		if baseStatus == 1 || headStatus == 1 {
-			comment.WriteString("Conversion issues:\n\n")
-			if baseStatus == 1 {
-				comment.WriteString("- Base conversion failed\n")
-			} else if baseStatus == 2 {
-				comment.WriteString("- Base flow file missing (added in PR)\n")
-			}
-			if headStatus == 1 {
-				comment.WriteString("- Head conversion failed\n")
-			} else if headStatus == 2 {
-				comment.WriteString("- Head flow file missing (deleted in PR)\n")
-			}
-			comment.WriteString("\n")
-			if len(baseLog) > 0 || len(headLog) > 0 {
-				comment.WriteString("```text\n")
-				if len(baseLog) > 0 {
-					comment.WriteString("[base]\n")
-					comment.Write(truncateBytes(baseLog, maxErrorChars))
-					comment.WriteString("\n")
-				}
-				if len(headLog) > 0 {
-					comment.WriteString("[head]\n")
-					comment.Write(truncateBytes(headLog, maxErrorChars))
-					comment.WriteString("\n")
-				}
-				comment.WriteString("```\n\n")
-			}
+	if shownFlows == 0 {
+		// Every flow was hidden by --changed-only, so there is nothing to
+		// report.
+		if err := comment.Close(); err != nil {
+			return exitFailure, fmt.Errorf("write comment file: %w", err)
+		}
+		if err := os.WriteFile(commentFile, []byte{}, 0o644); err != nil {
+			return exitFailure, fmt.Errorf("write empty comment file: %w", err)
 		}
+		if sideBySideHTML != nil {
+			sideBySideHTML.Close()
+			os.Remove(htmlFile)
+		}
+		if err := writeSummary(summaryFile, summary); err != nil {
+			return exitFailure, err
+		}
+		return exitNoChanges, appendOutputs(outputFile, []outputKV{
+			{Key: "has_flow_changes", Value: "false"},
+			{Key: "comment_file", Value: commentFile},
+			{Key: "html_file", Value: ""},
+			{Key: "summary_file", Value: summaryFile},
+			{Key: "per_flow_dir", Value: perFlowDir},
+		})
+	}
 
-		diffExit, diffText, err := diffRenderedOutputs(workspace, flowPath, baseDir, headDir, resolvedDiffFormat)
-		if err != nil {
-			return err
+	if comment.truncated {
+		// The note itself is written past the size limit.
+		comment.limit = 0
+		comment.WriteString("\n...comment truncated due to size limit...\n")
+		summary.Truncated = true
+	}
+	if err := comment.Close(); err != nil {
+		return exitFailure, fmt.Errorf("write comment file: %w", err)
+	}
+	if sideBySideHTML != nil {
+		sideBySideHTML.WriteString(sideBySideHTMLMainEnd)
+		sideBySideHTML.WriteString(sideBySideHTMLTOC(reportEntries))
+		sideBySideHTML.WriteString(sideBySideHTMLEnd)
+		if err := sideBySideHTML.Close(); err != nil {
+			return exitFailure, fmt.Errorf("write html file: %w", err)
 		}
-		switch diffExit {
-		case 1:
-			commentDiffText := diffText
-			if resolvedDiffFormat == diffFormatSideBySide {
-				commentDiffText = suppressCommonSideBySideDiffLines(diffText)
-			}
-			if resolvedDiffFormat == diffFormatSideBySide {
-				sideBySideHTML.WriteString("    <h2>")
-				sideBySideHTML.WriteString(html.EscapeString(flowPath))
-				sideBySideHTML.WriteString("</h2>\n")
-				sideBySideHTML.WriteString("    <pre class=\"sbs\"><span class=\"sbs-scale\">")
-				sideBySideHTML.WriteString(formatSideBySideDiffHTML(diffText))
-				sideBySideHTML.WriteString("</span></pre>\n")
-			}
+	}
 
-			commentDiffText = truncateDiff(commentDiffText)
-			if resolvedDiffFormat == diffFormatSideBySide {
-				comment.WriteString("```text\n")
-			} else {
-				comment.WriteString("```diff\n")
-			}
-			comment.WriteString(commentDiffText)
-			if !strings.HasSuffix(commentDiffText, "\n") {
-				comment.WriteString("\n")
-			}
-			comment.WriteString("```\n\n")
-		case 0:
-			comment.WriteString("No generated Apex differences.\n\n")
-			if resolvedDiffFormat == diffFormatSideBySide {
-				sideBySideHTML.WriteString("    <h2>")
-				sideBySideHTML.WriteString(html.EscapeString(flowPath))
-				sideBySideHTML.WriteString("</h2>\n")
-				sideBySideHTML.WriteString("    <p>No generated Apex differences.</p>\n")
-			}
-		default:
-			comment.WriteString("Failed to generate diff output.\n\n")
-			if resolvedDiffFormat == diffFormatSideBySide {
-				sideBySideHTML.WriteString("    <h2>")
-				sideBySideHTML.WriteString(html.EscapeString(flowPath))
-				sideBySideHTML.WriteString("</h2>\n")
-				sideBySideHTML.WriteString("    <p>Failed to generate diff output.</p>\n")
+	if omittedFlows > 0 {
+		summary.Truncated = true
+	}
+	if err := writeSummary(summaryFile, summary); err != nil {
+		return exitFailure, err
+	}
+
+	if err := appendOutputs(outputFile, []outputKV{
+		{Key: "has_flow_changes", Value: strconv.FormatBool(hasFlowChanges(summary))},
+		{Key: "comment_file", Value: commentFile},
+		{Key: "html_file", Value: htmlFileOutput},
+		{Key: "summary_file", Value: summaryFile},
+		{Key: "per_flow_dir", Value: perFlowDir},
+	}); err != nil {
+		return exitFailure, err
+	}
+	code := exitStatus(summary, exitCode)
+	if code == exitFailure {
+		return code, fmt.Errorf("diff failed for %d flow(s)", summary.DiffFailures)
+	}
+	return code, nil
+}
+
+// flowRun holds the settings and outputs shared by every flow in a run.
+type flowRun struct {
+	workspace      string
+	tmpDir         string
+	baseCheckout   string
+	headCheckout   string
+	flow2apexBin   string
+	againstOrg     string
+	renderTimeout  time.Duration
+	ignoreComments bool
+	changedOnly    bool
+	diffOpts       diffOptions
+	fence          string
+	flat           bool
+	highlight      bool
+
+	comment *streamWriter
+	// html is the side-by-side report; it is nil for unified diffs.
+	html          *streamWriter
+	perFlowDir    string
+	perFlowHeader string
+}
+
+// flowResult is what diffFlow found for one flow.
+type flowResult struct {
+	baseStatus int
+	headStatus int
+	// diffExit is 0 when the renders match, 1 when they differ, and
+	// anything else when diff failed.
+	diffExit int
+	// shown is false when --changed-only left the flow out of the comment
+	// and html report.
+	shown     bool
+	truncated bool
+}
+
+func (r flowResult) hasConversionIssue() bool {
+	return hasConversionIssue(r.baseStatus) || hasConversionIssue(r.headStatus)
+}
+
+// diffFlow renders flowPath from both sides, writes its section to the
+// comment, the html report, and the per-flow files, and reports the outcome.
+// An error ends the run.
+func (r *flowRun) diffFlow(ctx context.Context, flowPath string) (flowResult, error) {
+	var result flowResult
+	safe := sanitizeFlowPath(flowPath)
+	baseDir := filepath.Join(r.tmpDir, "base-render-"+safe)
+	headDir := filepath.Join(r.tmpDir, "head-render-"+safe)
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		return result, fmt.Errorf("create base render dir: %w", err)
+	}
+	if err := os.MkdirAll(headDir, 0o755); err != nil {
+		return result, fmt.Errorf("create head render dir: %w", err)
+	}
+
+	headStatus, headLog, err := renderFlow(ctx, r.headCheckout, r.flow2apexBin, flowPath, headDir, r.renderTimeout)
+	if err != nil {
+		return result, err
+	}
+	var baseStatus int
+	var baseLog []byte
+	if r.againstOrg != "" {
+		baseStatus, baseLog, err = renderFromOrg(ctx, r.againstOrg, headStatus, headDir, baseDir)
+	} else {
+		baseStatus, baseLog, err = renderFlow(ctx, r.baseCheckout, r.flow2apexBin, flowPath, baseDir, r.renderTimeout)
+	}
+	if err != nil {
+		return result, err
+	}
+	result.baseStatus, result.headStatus = baseStatus, headStatus
+	section := flowSection(flowPath, r.againstOrg, baseStatus, headStatus, baseLog, headLog)
+
+	if r.ignoreComments {
+		for _, dir := range []string{baseDir, headDir} {
+			if err := stripApexCommentsInDir(dir); err != nil {
+				return result, fmt.Errorf("strip comments for %s: %w", flowPath, err)
 			}
 		}
 	}
 
-	commentBody := comment.String()
-	if len(commentBody) > maxCommentChars {
-		commentBody = commentBody[:maxCommentChars] + "\n...comment truncated due to size limit...\n"
-	}
-	if err := os.WriteFile(commentFile, []byte(commentBody), 0o644); err != nil {
-		return fmt.Errorf("write comment file: %w", err)
+	changed, err := renderedOutputsDiffer(baseDir, headDir)
+	if err != nil {
+		return result, fmt.Errorf("compare renders for %s: %w", flowPath, err)
 	}
-	if resolvedDiffFormat == diffFormatSideBySide {
-		sideBySideHTML.WriteString("  </body>\n</html>\n")
-		if err := os.WriteFile(htmlFile, []byte(sideBySideHTML.String()), 0o644); err != nil {
-			return fmt.Errorf("write html file: %w", err)
+	if !changed {
+		if r.changedOnly && !result.hasConversionIssue() {
+			return result, nil
 		}
+		result.shown = true
+		r.comment.WriteString(section)
+		r.writeNote(flowPath, nil, "No generated Apex differences.")
+		return result, nil
 	}
 
-	return appendOutputs(outputFile, []outputKV{
-		{Key: "has_flow_changes", Value: "true"},
-		{Key: "comment_file", Value: commentFile},
-		{Key: "html_file", Value: htmlFileOutput},
-	})
+	// The section heading goes out first; the diff itself is copied into the
+	// comment and report as diff writes it.
+	result.shown = true
+	r.comment.WriteString(section)
+	out := &flowDiffWriter{
+		flowPath:      flowPath,
+		baseDir:       baseDir,
+		headDir:       headDir,
+		format:        r.diffOpts.format,
+		fence:         r.fence,
+		flat:          r.flat,
+		width:         r.diffOpts.width,
+		highlight:     r.highlight,
+		comment:       r.comment,
+		html:          r.html,
+		perFlowDir:    r.perFlowDir,
+		perFlowHeader: r.perFlowHeader,
+	}
+	result.diffExit, err = diffRenderedOutputs(ctx, r.workspace, flowPath, baseDir, headDir, r.diffOpts, out.writeLine)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return result, err
+	}
+	result.truncated, err = out.close(result.diffExit == 1)
+	if err != nil {
+		return result, err
+	}
+	switch result.diffExit {
+	case 1:
+	case 0:
+		r.writeNote(flowPath, out, "No generated Apex differences.")
+	default:
+		r.writeNote(flowPath, out, "Failed to generate diff output.")
+	}
+	return result, nil
+}
+
+// writeNote ends a flow's comment section and html report section with note.
+// out is the flow's diff writer, or nil when no diff was run.
+func (r *flowRun) writeNote(flowPath string, out *flowDiffWriter, note string) {
+	r.comment.WriteString(note + "\n\n")
+	if r.html == nil {
+		return
+	}
+	if out == nil || !out.htmlOpen {
+		writeSideBySideHTMLHeading(r.html, flowPath)
+	}
+	r.html.WriteString("    <p>" + note + "</p>\n")
 }
 
-func detectChangedFlows(workspace, baseSHA, headSHA string) ([]string, error) {
-	cmd := exec.Command("git", "diff", "--name-only", "--no-renames", "--diff-filter=ACMRD", baseSHA, headSHA)
+// flowSection returns a flow's comment heading followed by its conversion
+// issues, or its converter warnings collapsed when both sides converted.
+func flowSection(flowPath, againstOrg string, baseStatus, headStatus int, baseLog, headLog []byte) string {
+	var section strings.Builder
+	section.WriteString(fmt.Sprintf("### `%s`\n\n", flowPath))
+	if hasConversionIssue(baseStatus) || hasConversionIssue(headStatus) {
+		section.WriteString("Conversion issues:\n\n")
+		switch baseStatus {
+		case renderFailed:
+			if againstOrg != "" {
+				section.WriteString(fmt.Sprintf("- Retrieving Apex from org `%s` failed\n", againstOrg))
+			} else {
+				section.WriteString("- Base conversion failed\n")
+			}
+		case renderMissing:
+			if againstOrg != "" {
+				section.WriteString(fmt.Sprintf("- Generated Apex not deployed in org `%s`\n", againstOrg))
+			} else {
+				section.WriteString("- Base flow file missing (added in PR)\n")
+			}
+		case renderUnsupported:
+			section.WriteString("- Base converted with unsupported elements\n")
+		case renderTimedOut:
+			section.WriteString("- Base render timed out\n")
+		case renderSkipped:
+			section.WriteString(fmt.Sprintf("- Apex in org `%s` not retrieved\n", againstOrg))
+		}
+		switch headStatus {
+		case renderFailed:
+			section.WriteString("- Head conversion failed\n")
+		case renderMissing:
+			section.WriteString("- Head flow file missing (deleted in PR)\n")
+		case renderUnsupported:
+			section.WriteString("- Head converted with unsupported elements\n")
+		case renderTimedOut:
+			section.WriteString("- Head render timed out\n")
+		}
+		section.WriteString("\n")
+		writeConverterLogs(&section, baseLog, headLog)
+	} else if len(baseLog) > 0 || len(headLog) > 0 {
+		// Successful conversions can still warn about TODO placeholders or
+		// unsupported elements; keep those visible but collapsed.
+		section.WriteString("<details>\n<summary>Warnings</summary>\n\n")
+		writeConverterLogs(&section, baseLog, headLog)
+		section.WriteString("</details>\n\n")
+	}
+	return section.String()
+}
+
+func detectChangedFlows(ctx context.Context, workspace, baseSHA, headSHA string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", "--no-renames", "--diff-filter=ACMRD", baseSHA, headSHA)
 	cmd.Dir = workspace
 	out, err := cmd.Output()
 	if err != nil {
@@ -289,6 +717,172 @@ func detectChangedFlows(workspace, baseSHA, headSHA string) ([]string, error) {
 	return dedupe(flows), nil
 }
 
+// detectChangedFlowsInDirs lists flow files, relative to the directories,
+// that exist in only one of baseDir and headDir or whose contents differ.
+func detectChangedFlowsInDirs(baseDir, headDir string) ([]string, error) {
+	baseFlows, err := listFlowFiles(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	headFlows, err := listFlowFiles(headDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var flows []string
+	for _, flowPath := range dedupe(sortedUnion(baseFlows, headFlows)) {
+		if _, err := checkoutFlowPath(baseDir, flowPath); err != nil {
+			// Keep the flow so renderFlow reports the unusable path as a
+			// conversion failure instead of aborting the run.
+			flows = append(flows, flowPath)
+			continue
+		}
+		base, err := readFlowFile(baseDir, flowPath)
+		if err != nil {
+			return nil, err
+		}
+		head, err := readFlowFile(headDir, flowPath)
+		if err != nil {
+			return nil, err
+		}
+		if base == nil || head == nil || !bytes.Equal(base, head) {
+			flows = append(flows, flowPath)
+		}
+	}
+	return flows, nil
+}
+
+func listFlowFiles(dir string) ([]string, error) {
+	re := regexp.MustCompile(`\.flow(-meta\.xml)?$`)
+	var flows []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !re.MatchString(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		flows = append(flows, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list flow files in %s: %w", dir, err)
+	}
+	return flows, nil
+}
+
+// repoRelativeFlowPaths rewrites each absolute flow path that points inside
+// one of roots as a slash-separated path relative to that root, so the base
+// and head checkouts resolve the same repository path. Other paths are kept
+// for checkoutFlowPath to reject.
+func repoRelativeFlowPaths(flows []string, roots ...string) []string {
+	out := make([]string, 0, len(flows))
+	for _, flowPath := range flows {
+		path := filepath.FromSlash(flowPath)
+		if filepath.IsAbs(path) {
+			for _, root := range roots {
+				if root == "" {
+					continue
+				}
+				root, err := filepath.Abs(root)
+				if err != nil {
+					continue
+				}
+				if rel, err := filepath.Rel(root, path); err == nil && filepath.IsLocal(rel) {
+					flowPath = filepath.ToSlash(rel)
+					break
+				}
+			}
+		}
+		out = append(out, flowPath)
+	}
+	return out
+}
+
+// checkoutFlowPath resolves flowPath inside checkoutDir. Flow paths are
+// slash-separated and relative to the repository root, for example
+// force-app/main/default/flows/Example.flow-meta.xml; absolute paths and
+// paths that climb out of the checkout with ".." are rejected.
+func checkoutFlowPath(checkoutDir, flowPath string) (string, error) {
+	rel := filepath.FromSlash(flowPath)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("flow path %q must be relative to the repository root and stay inside it", flowPath)
+	}
+	return filepath.Join(checkoutDir, rel), nil
+}
+
+// readFlowFile returns the contents of flowPath under dir, or nil if it does
+// not exist.
+func readFlowFile(dir, flowPath string) ([]byte, error) {
+	path, err := checkoutFlowPath(dir, flowPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read flow file %s: %w", flowPath, err)
+	}
+	return data, nil
+}
+
+// listFlowFilesAtSHA lists every tracked flow file at sha.
+func listFlowFilesAtSHA(ctx context.Context, workspace, sha string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "git", "ls-tree", "-r", "-z", "--name-only", sha)
+	cmd.Dir = workspace
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("list flow files at %s: %w", sha, err)
+	}
+	re := regexp.MustCompile(`\.flow(-meta\.xml)?$`)
+	var flows []string
+	for _, path := range strings.Split(string(out), "\x00") {
+		if re.MatchString(path) {
+			flows = append(flows, path)
+		}
+	}
+	sort.Strings(flows)
+	return flows, nil
+}
+
+func sortedUnion(a, b []string) []string {
+	out := append(append([]string{}, a...), b...)
+	sort.Strings(out)
+	return out
+}
+
+// coalesceFlowPairs merges X.flow and X.flow-meta.xml into one logical flow
+// so a flow whose two files both changed is rendered once. The -meta.xml path
+// is kept when both are present. The result is sorted.
+func coalesceFlowPairs(flows []string) []string {
+	byName := make(map[string]string, len(flows))
+	for _, flowPath := range flows {
+		name := strings.TrimSuffix(flowPath, "-meta.xml")
+		if existing, ok := byName[name]; ok && strings.HasSuffix(existing, "-meta.xml") {
+			continue
+		}
+		byName[name] = flowPath
+	}
+	out := make([]string, 0, len(byName))
+	for _, flowPath := range byName {
+		out = append(out, flowPath)
+	}
+	sort.Strings(out)
+	return out
+}
+
 func dedupe(in []string) []string {
 	if len(in) < 2 {
 		return in
@@ -316,94 +910,421 @@ func resolveFlow2ApexBin(value string) (string, error) {
 		if info.Mode()&0o111 == 0 {
 			return "", fmt.Errorf("FLOW2APEX_BIN is not executable: %s", value)
 		}
-		return value, nil
-	}
-	resolved, err := exec.LookPath(value)
-	if err != nil {
-		return "", fmt.Errorf("flow2apex binary not found on PATH")
+		return value, nil
+	}
+	resolved, err := exec.LookPath(value)
+	if err != nil {
+		return "", fmt.Errorf("flow2apex binary not found on PATH")
+	}
+	return resolved, nil
+}
+
+// hasConversionIssue reports whether status should be listed under
+// "Conversion issues" in the comment.
+func hasConversionIssue(status int) bool {
+	return status == renderFailed || status == renderUnsupported || status == renderTimedOut || status == renderSkipped
+}
+
+// renderFlow converts flowPath from checkoutDir into outputDir and reports
+// one of the render* statuses along with any converter stderr. flowPath is
+// relative to the checkout root, as reported by git. A timeout of zero lets
+// each converter run as long as it needs. A flowPath outside the checkout is
+// reported as renderFailed with the reason in the log so the run continues.
+func renderFlow(ctx context.Context, checkoutDir, flow2apexBin, flowPath, outputDir string, timeout time.Duration) (int, []byte, error) {
+	flowFilePath, err := checkoutFlowPath(checkoutDir, flowPath)
+	if err != nil {
+		return renderFailed, []byte(err.Error() + "\n"), nil
+	}
+	if _, err := os.Stat(flowFilePath); err != nil {
+		if os.IsNotExist(err) {
+			return renderMissing, nil, nil
+		}
+		return renderFailed, nil, fmt.Errorf("stat flow file %s: %w", flowPath, err)
+	}
+
+	var log bytes.Buffer
+	status, stderr, err := runFlow2ApexToDir(ctx, checkoutDir, flow2apexBin, flowFilePath, outputDir, timeout)
+	if err != nil {
+		return renderFailed, nil, err
+	}
+	log.Write(stderr)
+	if status != renderFailed {
+		return status, log.Bytes(), nil
+	}
+
+	status, stderr, err = runFlow2ApexToStdout(ctx, checkoutDir, flow2apexBin, flowFilePath, filepath.Join(outputDir, "generated.apex"), timeout)
+	if err != nil {
+		return renderFailed, nil, err
+	}
+	log.Write(stderr)
+	return status, log.Bytes(), nil
+}
+
+// convertStatus maps a converter exit code to a render status.
+func convertStatus(exitCode int) int {
+	switch exitCode {
+	case 0:
+		return renderOK
+	case flow2apexExitUnsupported:
+		return renderUnsupported
+	default:
+		return renderFailed
+	}
+}
+
+// flow2apexCommand builds a converter invocation that is killed when ctx ends
+// or once timeout elapses. It returns the command's own context so callers
+// can tell a render timeout from the whole run being stopped. The returned
+// cancel func must be called after the command ends.
+func flow2apexCommand(ctx context.Context, checkoutDir, bin string, timeout time.Duration, args ...string) (*exec.Cmd, context.Context, context.CancelFunc) {
+	cmdCtx, cancel := context.WithCancel(ctx)
+	if timeout > 0 {
+		cmdCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	cmd := exec.CommandContext(cmdCtx, bin, args...)
+	cmd.Dir = checkoutDir
+	// Don't wait indefinitely on output pipes held open by orphaned children.
+	cmd.WaitDelay = 5 * time.Second
+	return cmd, cmdCtx, cancel
+}
+
+func runFlow2ApexToDir(ctx context.Context, checkoutDir, bin, flowFile, outputDir string, timeout time.Duration) (int, []byte, error) {
+	cmd, cmdCtx, cancel := flow2apexCommand(ctx, checkoutDir, bin, timeout, flowFile, "-d", outputDir)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd.Stdout = bytes.NewBuffer(nil)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return renderOK, stderr.Bytes(), nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return renderFailed, nil, ctxErr
+	}
+	if cmdCtx.Err() == context.DeadlineExceeded {
+		return renderTimedOut, stderr.Bytes(), nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return convertStatus(exitErr.ExitCode()), stderr.Bytes(), nil
+	}
+	return renderFailed, nil, fmt.Errorf("run flow2apex with output-dir: %w", err)
+}
+
+// runFlow2ApexToStdout streams the converter's stdout into outputPath, which
+// is removed again unless the conversion succeeded.
+func runFlow2ApexToStdout(ctx context.Context, checkoutDir, bin, flowFile, outputPath string, timeout time.Duration) (int, []byte, error) {
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return renderFailed, nil, fmt.Errorf("create generated apex fallback: %w", err)
+	}
+	defer out.Close()
+
+	cmd, cmdCtx, cancel := flow2apexCommand(ctx, checkoutDir, bin, timeout, flowFile)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	status := renderOK
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		return renderFailed, nil, ctx.Err()
+	case cmdCtx.Err() == context.DeadlineExceeded:
+		status = renderTimedOut
+	default:
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return renderFailed, nil, fmt.Errorf("run flow2apex fallback: %w", err)
+		}
+		status = convertStatus(exitErr.ExitCode())
+	}
+	if status != renderOK && status != renderUnsupported {
+		// Keep partial output from a failed run out of the diff.
+		out.Close()
+		if err := os.Remove(outputPath); err != nil {
+			return renderFailed, nil, fmt.Errorf("remove generated apex fallback: %w", err)
+		}
+	}
+	return status, stderr.Bytes(), nil
+}
+
+// prepareCheckout makes flows at sha available under dir. It prefers a
+// detached worktree, limited to the flow files when sparse is set, and falls
+// back to extracting just the flow files with git archive when worktree add
+// fails, as it can on shallow clones or old git. The returned cleanup func
+// removes the worktree, if one was created.
+func prepareCheckout(ctx context.Context, workspace, sha, dir string, flows []string, sparse bool) (func() error, error) {
+	var sparsePaths []string
+	if sparse {
+		sparsePaths = sparseCheckoutPatterns(flows)
+	}
+	worktreeErr := createDetachedWorktree(ctx, workspace, sha, dir, sparsePaths)
+	if worktreeErr == nil {
+		return func() error { return removeWorktree(workspace, dir) }, nil
+	}
+	if ctx.Err() != nil {
+		// The run was stopped while git was adding the worktree; don't leave
+		// a half-registered worktree behind or start the fallback.
+		if err := discardWorktree(workspace, dir); err != nil {
+			warnf("%v", err)
+		}
+		return nil, worktreeErr
+	}
+
+	warnf("%v; extracting flow files with git archive instead", worktreeErr)
+	if err := discardWorktree(workspace, dir); err != nil {
+		return nil, err
+	}
+	if err := extractFlowsFromArchive(ctx, workspace, sha, dir, flows); err != nil {
+		return nil, fmt.Errorf("%v; archive fallback: %w", worktreeErr, err)
+	}
+	return func() error { return nil }, nil
+}
+
+// extractFlowsFromArchive writes the flows that exist at sha into dir using
+// git archive. Flows missing at sha are skipped so renderFlow reports them as
+// added or deleted.
+func extractFlowsFromArchive(ctx context.Context, workspace, sha, dir string, flows []string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create checkout dir: %w", err)
+	}
+	if len(flows) == 0 {
+		return nil
+	}
+
+	lsTree := exec.CommandContext(ctx, "git", append([]string{"ls-tree", "-r", "-z", "--name-only", sha, "--"}, flows...)...)
+	lsTree.Dir = workspace
+	out, err := lsTree.Output()
+	if err != nil {
+		return fmt.Errorf("list flow files at %s: %w", sha, err)
+	}
+	var paths []string
+	for _, path := range strings.Split(string(out), "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{"archive", "--format=tar", sha, "--"}, paths...)...)
+	cmd.Dir = workspace
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("git archive %s: %w", sha, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("git archive %s: %w", sha, err)
+	}
+	extractErr := extractTar(stdout, dir)
+	if extractErr != nil {
+		// Drain the pipe so git can exit before Wait.
+		io.Copy(io.Discard, stdout)
+	}
+	if err := cmd.Wait(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg != "" {
+			return fmt.Errorf("git archive %s: %s", sha, msg)
+		}
+		return fmt.Errorf("git archive %s: %w", sha, err)
+	}
+	return extractErr
+}
+
+// extractTar writes the regular files in a tar stream below dir, rejecting
+// entries that would land outside it.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("archive entry %q escapes checkout dir", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return fmt.Errorf("create dir for %s: %w", hdr.Name, err)
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("create %s: %w", hdr.Name, err)
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return fmt.Errorf("write %s: %w", hdr.Name, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("close %s: %w", hdr.Name, err)
+		}
+	}
+}
+
+// createDetachedWorktree adds a detached worktree for sha at dir. When
+// sparsePatterns is non-empty only matching paths are checked out.
+func createDetachedWorktree(ctx context.Context, workspace, sha, dir string, sparsePatterns []string) error {
+	args := []string{"worktree", "add", "--detach"}
+	if len(sparsePatterns) > 0 {
+		args = append(args, "--no-checkout")
+	}
+	args = append(args, dir, sha)
+	if err := runGit(ctx, workspace, nil, args...); err != nil {
+		return fmt.Errorf("create worktree for %s: %w", sha, err)
+	}
+	if len(sparsePatterns) == 0 {
+		return nil
+	}
+
+	// git sparse-checkout set would turn on extensions.worktreeConfig in
+	// the shared repository config, which outlives the worktree. Write the
+	// patterns to the worktree's own sparse-checkout file and enable sparse
+	// checkout for the read-tree call alone so the workspace is untouched.
+	sparseFile, err := gitOutput(ctx, dir, "rev-parse", "--path-format=absolute", "--git-path", "info/sparse-checkout")
+	if err != nil {
+		return fmt.Errorf("locate sparse checkout file for %s: %w", sha, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(sparseFile), 0o755); err != nil {
+		return fmt.Errorf("set sparse checkout for %s: %w", sha, err)
+	}
+	if err := os.WriteFile(sparseFile, []byte(strings.Join(sparsePatterns, "\n")+"\n"), 0o644); err != nil {
+		return fmt.Errorf("set sparse checkout for %s: %w", sha, err)
+	}
+	if err := runGit(ctx, dir, nil, "-c", "core.sparseCheckout=true", "-c", "core.sparseCheckoutCone=false", "read-tree", "-mu", "HEAD"); err != nil {
+		return fmt.Errorf("populate sparse checkout for %s: %w", sha, err)
+	}
+	return nil
+}
+
+// gitOutput runs git in dir and returns its trimmed stdout, reporting its
+// stderr when it fails.
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runGit runs git in dir, reporting its stderr when it fails.
+func runGit(ctx context.Context, dir string, stdin io.Reader, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s", msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// sparseCheckoutPatterns returns anchored non-cone patterns for each flow and
+// its .flow/.flow-meta.xml sibling.
+func sparseCheckoutPatterns(flows []string) []string {
+	var patterns []string
+	seen := make(map[string]bool)
+	for _, flowPath := range flows {
+		paths := []string{flowPath}
+		if strings.HasSuffix(flowPath, ".flow-meta.xml") {
+			paths = append(paths, strings.TrimSuffix(flowPath, "-meta.xml"))
+		} else if strings.HasSuffix(flowPath, ".flow") {
+			paths = append(paths, flowPath+"-meta.xml")
+		}
+		for _, path := range paths {
+			pattern := "/" + escapeSparsePattern(path)
+			if !seen[pattern] {
+				seen[pattern] = true
+				patterns = append(patterns, pattern)
+			}
+		}
 	}
-	return resolved, nil
+	return patterns
 }
 
-func renderFlow(checkoutDir, flow2apexBin, flowPath, outputDir string) (int, []byte, error) {
-	flowFilePath := filepath.Join(checkoutDir, filepath.FromSlash(flowPath))
-	if _, err := os.Stat(flowFilePath); err != nil {
-		if os.IsNotExist(err) {
-			return 2, nil, nil
+func escapeSparsePattern(path string) string {
+	var out strings.Builder
+	for _, r := range path {
+		switch r {
+		case '\\', '*', '?', '[', ' ':
+			out.WriteRune('\\')
 		}
-		return 1, nil, fmt.Errorf("stat flow file %s: %w", flowPath, err)
+		out.WriteRune(r)
 	}
+	return out.String()
+}
 
-	var log bytes.Buffer
-	ok, stderr, err := runFlow2ApexToDir(checkoutDir, flow2apexBin, flowFilePath, outputDir)
-	if err != nil {
-		return 1, nil, err
-	}
-	log.Write(stderr)
-	if ok {
-		return 0, log.Bytes(), nil
-	}
+// minSparseGitMajor and minSparseGitMinor give the oldest git sparse
+// worktrees are used with; it matches the non-cone pattern handling and
+// rev-parse --path-format that createDetachedWorktree relies on.
+const (
+	minSparseGitMajor = 2
+	minSparseGitMinor = 36
+)
 
-	ok, stdout, stderr, err := runFlow2ApexToStdout(checkoutDir, flow2apexBin, flowFilePath)
+// gitSupportsSparseCheckout reports whether the installed git is new enough
+// for sparse worktrees. Detection failures fall back to full checkouts.
+func gitSupportsSparseCheckout(workspace string) bool {
+	cmd := exec.Command("git", "version")
+	cmd.Dir = workspace
+	out, err := cmd.Output()
 	if err != nil {
-		return 1, nil, err
+		return false
 	}
-	log.Write(stderr)
-	if ok {
-		if err := os.WriteFile(filepath.Join(outputDir, "generated.apex"), stdout, 0o644); err != nil {
-			return 1, nil, fmt.Errorf("write generated apex fallback: %w", err)
-		}
-		return 0, log.Bytes(), nil
+	major, minor, ok := parseGitVersion(string(out))
+	if !ok {
+		return false
 	}
-	return 1, log.Bytes(), nil
+	return major > minSparseGitMajor || (major == minSparseGitMajor && minor >= minSparseGitMinor)
 }
 
-func runFlow2ApexToDir(checkoutDir, bin, flowFile, outputDir string) (bool, []byte, error) {
-	cmd := exec.Command(bin, flowFile, "-d", outputDir)
-	cmd.Dir = checkoutDir
-	var stderr bytes.Buffer
-	cmd.Stdout = bytes.NewBuffer(nil)
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	if err == nil {
-		return true, stderr.Bytes(), nil
-	}
-	if _, ok := err.(*exec.ExitError); ok {
-		return false, stderr.Bytes(), nil
+// parseGitVersion extracts the major and minor numbers from git version
+// output such as "git version 2.39.5" or "git version 2.39.3 (Apple Git-146)".
+func parseGitVersion(output string) (int, int, bool) {
+	fields := strings.Fields(output)
+	if len(fields) < 3 || fields[0] != "git" || fields[1] != "version" {
+		return 0, 0, false
 	}
-	return false, nil, fmt.Errorf("run flow2apex with output-dir: %w", err)
-}
-
-func runFlow2ApexToStdout(checkoutDir, bin, flowFile string) (bool, []byte, []byte, error) {
-	cmd := exec.Command(bin, flowFile)
-	cmd.Dir = checkoutDir
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	if err == nil {
-		return true, stdout.Bytes(), stderr.Bytes(), nil
+	parts := strings.SplitN(fields[2], ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
 	}
-	if _, ok := err.(*exec.ExitError); ok {
-		return false, stdout.Bytes(), stderr.Bytes(), nil
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
 	}
-	return false, nil, nil, fmt.Errorf("run flow2apex fallback: %w", err)
-}
-
-func createDetachedWorktree(workspace, sha, dir string) error {
-	cmd := exec.Command("git", "worktree", "add", "--detach", dir, sha)
-	cmd.Dir = workspace
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		msg := strings.TrimSpace(stderr.String())
-		if msg != "" {
-			return fmt.Errorf("create worktree for %s: %s", sha, msg)
-		}
-		return fmt.Errorf("create worktree for %s: %w", sha, err)
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
 	}
-	return nil
+	return major, minor, true
 }
 
 func removeWorktree(workspace, dir string) error {
@@ -424,32 +1345,139 @@ func removeWorktree(workspace, dir string) error {
 	return nil
 }
 
-func diffRenderedOutputs(workspace, flowPath, baseDir, headDir, diffFormat string) (int, string, error) {
-	switch diffFormat {
+// discardWorktree cleans up after a worktree add that failed or was killed
+// part way through. It force-removes any worktree registered at dir, even
+// one git left locked, deletes what remains of dir, and prunes the stale
+// entry.
+func discardWorktree(workspace, dir string) error {
+	remove := exec.Command("git", "worktree", "remove", "--force", "--force", dir)
+	remove.Dir = workspace
+	if out, err := remove.CombinedOutput(); err != nil {
+		if _, statErr := os.Stat(dir); statErr == nil {
+			msg := strings.TrimSpace(string(out))
+			if msg == "" {
+				msg = err.Error()
+			}
+			warnf("remove worktree %s: %s; deleting the directory instead", dir, msg)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("reset checkout dir %s: %w", dir, err)
+	}
+	prune := exec.Command("git", "worktree", "prune")
+	prune.Dir = workspace
+	if out, err := prune.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("prune worktrees: %s", msg)
+		}
+		return fmt.Errorf("prune worktrees: %w", err)
+	}
+	return nil
+}
+
+// diffOptions holds the resolved settings used to diff rendered outputs.
+type diffOptions struct {
+	format string
+	width  int
+	native bool
+}
+
+// diffRenderedOutputs diffs a flow's base and head renders, passing each line
+// of output to emit as it is produced, and returns diff's exit code.
+func diffRenderedOutputs(ctx context.Context, workspace, flowPath, baseDir, headDir string, opts diffOptions, emit diffLineFunc) (int, error) {
+	switch opts.format {
 	case diffFormatSideBySide:
-		diffExit, diffText, err := diffSideBySide(workspace, flowPath, baseDir, headDir)
+		if opts.native {
+			return diffSideBySideNative(flowPath, baseDir, headDir, opts.width, emit)
+		}
+		diffExit, err := diffSideBySide(ctx, workspace, flowPath, baseDir, headDir, opts.width, emit)
 		if err != nil {
-			return 2, "", err
+			return 2, err
 		}
-		return diffExit, diffText, nil
+		return diffExit, nil
 	default:
-		cmd := exec.Command(
-			"git",
-			"diff",
-			"--no-index",
-			"--src-prefix=a/"+flowPath+"/",
-			"--dst-prefix=b/"+flowPath+"/",
-			"--",
-			baseDir,
-			headDir,
-		)
-		cmd.Dir = workspace
-		diffExit, diffText, _, err := runDiffCommand(cmd)
+		cmd := buildUnifiedDiffCommand(ctx, workspace, flowPath, baseDir, headDir)
+		diffExit, _, err := runDiffCommand(cmd, emit)
 		if err != nil {
-			return 2, "", fmt.Errorf("generate diff output: %w", err)
+			return 2, fmt.Errorf("generate diff output: %w", err)
+		}
+		return diffExit, nil
+	}
+}
+
+func buildUnifiedDiffCommand(ctx context.Context, workspace, flowPath, baseDir, headDir string) *exec.Cmd {
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		"diff",
+		"--no-index",
+		"--src-prefix=a/"+flowPath+"/",
+		"--dst-prefix=b/"+flowPath+"/",
+		"--",
+		baseDir,
+		headDir,
+	)
+	cmd.Dir = workspace
+	return cmd
+}
+
+// writeDryRunPlan describes what run would do for flows without touching the
+// filesystem. Temporary paths are shown relative to a <tmp> placeholder.
+//
+// When againstOrg is set the base side is retrieved from that org instead of
+// being rendered.
+func writeDryRunPlan(w io.Writer, workspace string, flows []string, baseCheckout, headCheckout, againstOrg, flow2apexBin string, binErr error, opts diffOptions) {
+	fmt.Fprintf(w, "Detected %d changed flow file(s):\n", len(flows))
+	for _, flowPath := range flows {
+		fmt.Fprintf(w, "  %s\n", flowPath)
+	}
+	if len(flows) == 0 {
+		return
+	}
+
+	if binErr != nil {
+		fmt.Fprintf(w, "flow2apex binary: unresolved (%v)\n", binErr)
+		flow2apexBin = "flow2apex"
+	} else {
+		fmt.Fprintf(w, "flow2apex binary: %s\n", flow2apexBin)
+	}
+	fmt.Fprintf(w, "Diff format: %s\n", opts.format)
+
+	tmpDir := "<tmp>"
+	for _, flowPath := range flows {
+		safe := sanitizeFlowPath(flowPath)
+		baseDir := filepath.Join(tmpDir, "base-render-"+safe)
+		headDir := filepath.Join(tmpDir, "head-render-"+safe)
+		fmt.Fprintf(w, "\n%s:\n", flowPath)
+		for _, side := range []struct {
+			name      string
+			checkout  string
+			outputDir string
+		}{
+			{name: "base", checkout: baseCheckout, outputDir: baseDir},
+			{name: "head", checkout: headCheckout, outputDir: headDir},
+		} {
+			if side.name == "base" && againstOrg != "" {
+				fmt.Fprintf(w, "  retrieve base: sf data query --use-tooling-api --target-org %s (each generated class and trigger) > %s\n", againstOrg, side.outputDir)
+				continue
+			}
+			flowFile := filepath.Join(side.checkout, filepath.FromSlash(flowPath))
+			fmt.Fprintf(w, "  render %s: %s %s -d %s\n", side.name, flow2apexBin, flowFile, side.outputDir)
+			fmt.Fprintf(w, "  render %s fallback: %s %s > %s\n", side.name, flow2apexBin, flowFile, filepath.Join(side.outputDir, "generated.apex"))
 		}
-		return diffExit, diffText, nil
+		fmt.Fprintf(w, "  diff: %s\n", describeDiffCommand(workspace, flowPath, baseDir, headDir, opts))
+	}
+}
+
+func describeDiffCommand(workspace, flowPath, baseDir, headDir string, opts diffOptions) string {
+	if opts.format != diffFormatSideBySide {
+		return strings.Join(buildUnifiedDiffCommand(context.Background(), workspace, flowPath, baseDir, headDir).Args, " ")
 	}
+	if opts.native {
+		return fmt.Sprintf("built-in side-by-side differ (width %d) %s %s", opts.width, baseDir, headDir)
+	}
+	cmd := buildSideBySideDiffCommand(context.Background(), workspace, baseDir, headDir, opts.width, sideBySideAttempts()[0])
+	return strings.Join(cmd.Args, " ")
 }
 
 func normalizeDiffFormat(value string) (string, error) {
@@ -463,19 +1491,133 @@ func normalizeDiffFormat(value string) (string, error) {
 	}
 }
 
+func normalizeCommentFence(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", commentFenceDiff:
+		return commentFenceDiff, nil
+	case commentFenceText:
+		return commentFenceText, nil
+	default:
+		return "", fmt.Errorf("invalid comment-fence %q (expected %q or %q)", value, commentFenceDiff, commentFenceText)
+	}
+}
+
+func normalizeSideBySideWidth(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return sideBySideWidth, nil
+	}
+	width, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid width %q: %w", value, err)
+	}
+	if width < minSideBySideWidth {
+		return 0, fmt.Errorf("invalid width %d (minimum %d)", width, minSideBySideWidth)
+	}
+	return width, nil
+}
+
+// normalizeMaxFlows parses the --max-flows limit. Zero means unlimited.
+func normalizeMaxFlows(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max-flows %q: %w", value, err)
+	}
+	if limit < 0 {
+		return 0, fmt.Errorf("invalid max-flows %d (must not be negative)", limit)
+	}
+	return limit, nil
+}
+
+// limitFlows keeps the first limit flows, which are already sorted, and
+// reports how many were dropped. A limit of zero keeps every flow.
+func limitFlows(flows []string, limit int) ([]string, int) {
+	if limit <= 0 || len(flows) <= limit {
+		return flows, 0
+	}
+	return flows[:limit], len(flows) - limit
+}
+
+func omittedFlowsNote(omitted, limit int) string {
+	return fmt.Sprintf("%d additional changed flow file(s) not shown (max-flows is %d).", omitted, limit)
+}
+
+func sideBySideHTMLOmittedNote(omitted, limit int) string {
+	if omitted == 0 {
+		return ""
+	}
+	return "    <p>" + html.EscapeString(omittedFlowsNote(omitted, limit)) + "</p>\n"
+}
+
+// normalizeRenderTimeout parses a Go duration such as "90s" or a bare number
+// of seconds. Zero disables the timeout.
+func normalizeRenderTimeout(value string) (time.Duration, error) {
+	return parseTimeout("render-timeout", value, defaultRenderTimeout)
+}
+
+// parseTimeout accepts a Go duration or a number of seconds for the option
+// called name, returning def when value is empty. Zero means no limit.
+func parseTimeout(name, value string, def time.Duration) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return def, nil
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		value = strconv.Itoa(seconds) + "s"
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("invalid %s %q (must not be negative)", name, value)
+	}
+	return timeout, nil
+}
+
+// runError returns err, or explains that the run was stopped once ctx has
+// ended, since the error from a killed subprocess says little on its own.
+func runError(ctx context.Context, err error) error {
+	switch ctxErr := ctx.Err(); {
+	case errors.Is(ctxErr, context.DeadlineExceeded):
+		return fmt.Errorf("flowdiff timed out: %w", ctxErr)
+	case ctxErr != nil:
+		return fmt.Errorf("flowdiff canceled: %w", ctxErr)
+	}
+	return err
+}
+
+func envBool(name string) bool {
+	value, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(name)))
+	return err == nil && value
+}
+
 func diffCommentMarker(diffFormat string) string {
 	return fmt.Sprintf("<!-- flow2apex-diff-comment:%s -->", diffFormat)
 }
 
-func startSideBySideHTMLReport(baseSHA, headSHA string) string {
+// startSideBySideHTMLReport returns the report's head and intro. When scale is
+// set, an inline script shrinks wide diff blocks to fit the page.
+func startSideBySideHTMLReport(baseSHA, headSHA string, width int, scale bool) string {
+	fitScript := ""
+	if scale {
+		fitScript = sideBySideHTMLFitScript(width)
+	}
 	return "<!doctype html>\n<html lang=\"en\">\n" +
 		"  <head>\n" +
 		"    <meta charset=\"utf-8\" />\n" +
 		"    <meta name=\"viewport\" content=\"width=device-width, initial-scale=1\" />\n" +
 		"    <title>flow2apex Side-By-Side Diff</title>\n" +
 		"    <style>\n" +
-		"      :root { color-scheme: light; }\n" +
-		"      body { margin: 24px; font-family: ui-monospace, SFMono-Regular, Menlo, Monaco, Consolas, \"Liberation Mono\", \"Courier New\", monospace; color: #1f2328; background: #ffffff; }\n" +
+		"      :root { color-scheme: light dark; }\n" +
+		// The table of contents is written after the flow sections so the
+		// report can be streamed; ordering the body's flex items puts the
+		// title and intro first and the table of contents above the flows.
+		"      body { display: flex; flex-direction: column; margin: 24px; font-family: ui-monospace, SFMono-Regular, Menlo, Monaco, Consolas, \"Liberation Mono\", \"Courier New\", monospace; color: #1f2328; background: #ffffff; }\n" +
 		"      h1 { margin: 0 0 12px 0; font-size: 22px; }\n" +
 		"      h2 { margin: 24px 0 8px 0; font-size: 16px; }\n" +
 		"      p { margin: 0 0 12px 0; font-size: 13px; }\n" +
@@ -485,10 +1627,115 @@ func startSideBySideHTMLReport(baseSHA, headSHA string) string {
 		"      .left { color: #cf222e; }\n" +
 		"      .right { color: #1a7f37; }\n" +
 		"      .sep { color: #656d76; }\n" +
+		"      h1, body > p { order: -2; }\n" +
+		"      nav.toc { order: -1; }\n" +
+		"      nav.toc ul { margin: 0 0 16px 0; padding-left: 20px; font-size: 13px; }\n" +
+		"      nav.toc li { margin: 2px 0; }\n" +
+		"      .toc-status { margin-left: 6px; font-size: 11px; color: #656d76; }\n" +
+		"      .toc-status.changed { color: #9a6700; }\n" +
+		"      .toc-status.failed { color: #cf222e; }\n" +
+		"      .kw { color: #8250df; font-weight: 600; }\n" +
+		"      .str { color: #0a3069; }\n" +
+		"      .com { color: #6e7781; font-style: italic; }\n" +
+		"      #reset-zoom { position: fixed; right: 16px; bottom: 16px; padding: 6px 10px; font: inherit; font-size: 12px; border: 1px solid #d0d7de; border-radius: 6px; background: #f6f8fa; color: inherit; cursor: pointer; }\n" +
+		"      #reset-zoom[hidden] { display: none; }\n" +
+		"      .left .kw, .left .str, .left .com, .right .kw, .right .str, .right .com { color: inherit; }\n" +
+		"      @media (prefers-color-scheme: dark) {\n" +
+		"        body { color: #e6edf3; background: #0d1117; }\n" +
+		"        a { color: #4493f8; }\n" +
+		"        code { background: #161b22; }\n" +
+		"        pre.sbs, #reset-zoom { border-color: #30363d; background: #161b22; }\n" +
+		"        .left { color: #ff7b72; }\n" +
+		"        .right { color: #3fb950; }\n" +
+		"        .sep { color: #9198a1; }\n" +
+		"        .kw { color: #d2a8ff; }\n" +
+		"        .str { color: #a5d6ff; }\n" +
+		"        .com { color: #9198a1; }\n" +
+		"        .toc-status { color: #9198a1; }\n" +
+		"        .toc-status.changed { color: #d29922; }\n" +
+		"        .toc-status.failed { color: #ff7b72; }\n" +
+		"      }\n" +
 		"    </style>\n" +
-		"    <script>\n" +
+		fitScript +
+		"  </head>\n" +
+		"  <body>\n" +
+		sideBySideHTMLResetControl(scale) +
+		"    <h1>flow2apex Side-By-Side Diffs</h1>\n" +
+		"    <p>Compared generated Apex between base <code>" + html.EscapeString(baseSHA) + "</code> and head <code>" + html.EscapeString(headSHA) + "</code>.</p>\n"
+}
+
+// Markup around the flow sections of a side-by-side html report.
+const (
+	sideBySideHTMLMainStart = "    <main>\n"
+	sideBySideHTMLMainEnd   = "    </main>\n"
+	sideBySideHTMLEnd       = "  </body>\n</html>\n"
+)
+
+// Report entry statuses shown in the side-by-side table of contents.
+const (
+	reportChanged   = "changed"
+	reportUnchanged = "unchanged"
+	reportFailed    = "failed"
+)
+
+// reportEntry records one flow section of the side-by-side HTML report.
+type reportEntry struct {
+	FlowPath string
+	Status   string
+}
+
+// sideBySideHTMLAnchor returns the stable element id for a flow's section.
+func sideBySideHTMLAnchor(flowPath string) string {
+	return "flow-" + sanitizeFlowPath(flowPath)
+}
+
+func writeSideBySideHTMLHeading(b io.StringWriter, flowPath string) {
+	b.WriteString("    <h2 id=\"")
+	b.WriteString(html.EscapeString(sideBySideHTMLAnchor(flowPath)))
+	b.WriteString("\">")
+	b.WriteString(html.EscapeString(flowPath))
+	b.WriteString("</h2>\n")
+}
+
+// sideBySideHTMLTOC renders a list of links to each flow section, labelled
+// with whether its generated Apex changed.
+func sideBySideHTMLTOC(entries []reportEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("    <nav class=\"toc\">\n      <ul>\n")
+	for _, entry := range entries {
+		b.WriteString("        <li><a href=\"#")
+		b.WriteString(html.EscapeString(sideBySideHTMLAnchor(entry.FlowPath)))
+		b.WriteString("\">")
+		b.WriteString(html.EscapeString(entry.FlowPath))
+		b.WriteString("</a><span class=\"toc-status ")
+		b.WriteString(entry.Status)
+		b.WriteString("\">")
+		b.WriteString(entry.Status)
+		b.WriteString("</span></li>\n")
+	}
+	b.WriteString("      </ul>\n    </nav>\n")
+	return b.String()
+}
+
+func sideBySideHTMLResetControl(scale bool) string {
+	if !scale {
+		return ""
+	}
+	return "    <button id=\"reset-zoom\" type=\"button\" onclick=\"resetZoom()\" hidden>Reset zoom</button>\n"
+}
+
+// sideBySideHTMLFitScript returns the auto-fit script used with the "reset
+// zoom" control. Refits after load are skipped for users who prefer reduced
+// motion.
+func sideBySideHTMLFitScript(width int) string {
+	return "    <script>\n" +
+		"      let scalingDisabled = false;\n" +
 		"      function fitSideBySideDiffs() {\n" +
 		"        const blocks = document.querySelectorAll('pre.sbs');\n" +
+		"        let scaled = false;\n" +
 		"        for (const pre of blocks) {\n" +
 		"          const scaleNode = pre.querySelector('.sbs-scale');\n" +
 		"          if (!scaleNode) {\n" +
@@ -498,13 +1745,16 @@ func startSideBySideHTMLReport(baseSHA, headSHA string) string {
 		"          pre.style.height = '';\n" +
 		"          pre.style.overflowX = 'auto';\n" +
 		"          pre.style.overflowY = 'hidden';\n" +
+		"          if (scalingDisabled) {\n" +
+		"            continue;\n" +
+		"          }\n" +
 		"          const available = pre.clientWidth;\n" +
 		"          const needed = scaleNode.scrollWidth;\n" +
 		"          if (!available || !needed || needed <= available) {\n" +
 		"            continue;\n" +
 		"          }\n" +
 		"          const scale = available / needed;\n" +
-		"          const minScale = 1.0;\n" +
+		"          const minScale = " + sideBySideHTMLMinScale(width) + ";\n" +
 		"          if (scale < minScale) {\n" +
 		"            continue;\n" +
 		"          }\n" +
@@ -512,119 +1762,143 @@ func startSideBySideHTMLReport(baseSHA, headSHA string) string {
 		"          pre.style.height = Math.ceil((scaleNode.scrollHeight * scale) + 24) + 'px';\n" +
 		"          pre.style.overflowX = 'hidden';\n" +
 		"          pre.style.overflowY = 'hidden';\n" +
+		"          scaled = true;\n" +
 		"        }\n" +
+		"        const reset = document.getElementById('reset-zoom');\n" +
+		"        if (reset) {\n" +
+		"          reset.hidden = !scaled;\n" +
+		"        }\n" +
+		"      }\n" +
+		"      function resetZoom() {\n" +
+		"        scalingDisabled = true;\n" +
+		"        fitSideBySideDiffs();\n" +
 		"      }\n" +
 		"      function scheduleFit() {\n" +
 		"        fitSideBySideDiffs();\n" +
+		"        if (window.matchMedia && window.matchMedia('(prefers-reduced-motion: reduce)').matches) {\n" +
+		"          return;\n" +
+		"        }\n" +
 		"        window.requestAnimationFrame(fitSideBySideDiffs);\n" +
 		"        window.setTimeout(fitSideBySideDiffs, 120);\n" +
 		"      }\n" +
 		"      window.addEventListener('load', scheduleFit);\n" +
 		"      window.addEventListener('resize', fitSideBySideDiffs);\n" +
-		"    </script>\n" +
-		"  </head>\n" +
-		"  <body>\n" +
-		"    <h1>flow2apex Side-By-Side Diffs</h1>\n" +
-		"    <p>Compared generated Apex between base <code>" + html.EscapeString(baseSHA) + "</code> and head <code>" + html.EscapeString(headSHA) + "</code>.</p>\n"
+		"    </script>\n"
 }
 
-func rewriteSideBySideDiffPaths(diffText, flowPath, baseDir, headDir string) string {
-	replacer := strings.NewReplacer(
-		baseDir, "a/"+flowPath,
-		headDir, "b/"+flowPath,
-	)
-	return replacer.Replace(diffText)
+// sideBySideHTMLMinScale returns the smallest transform scale the report script
+// may apply, scaled so wider diff output can still be fit to the page.
+func sideBySideHTMLMinScale(width int) string {
+	scale := sideBySideMinScale
+	if width > sideBySideWidth {
+		scale = sideBySideMinScale * float64(sideBySideWidth) / float64(width)
+	}
+	return strconv.FormatFloat(scale, 'f', 4, 64)
 }
 
-func diffSideBySide(workspace, flowPath, baseDir, headDir string) (int, string, error) {
-	type sideBySideAttempt struct {
-		expandTabs bool
-	}
-	attempts := []sideBySideAttempt{
-		{expandTabs: true},
-		{expandTabs: false},
+// sideBySideAttempt describes one diff invocation in the fallback matrix used
+// to cope with diff implementations that lack some GNU long options.
+type sideBySideAttempt struct {
+	shortFlags bool
+	expandTabs bool
+	tabSize    bool
+	newFile    bool
+}
+
+// sideBySideAttempts lists diff invocations from most to least capable. Long
+// options are dropped one at a time before retrying with GNU-style short flags,
+// which have no --tabsize equivalent.
+func sideBySideAttempts() []sideBySideAttempt {
+	return []sideBySideAttempt{
+		{expandTabs: true, tabSize: true, newFile: true},
+		{expandTabs: false, tabSize: true, newFile: true},
+		{expandTabs: false, tabSize: false, newFile: true},
+		{expandTabs: false, tabSize: false, newFile: false},
+		{shortFlags: true, expandTabs: true, newFile: true},
+		{shortFlags: true, expandTabs: false, newFile: true},
+		{shortFlags: true, expandTabs: false, newFile: false},
 	}
+}
 
-	for _, attempt := range attempts {
-		cmd := buildSideBySideDiffCommand(workspace, baseDir, headDir, attempt.expandTabs)
-		diffExit, diffText, stderrText, err := runDiffCommand(cmd)
+// diffSideBySide runs diff --side-by-side, dropping options the installed
+// diff rejects, and passes its rewritten output to emit. A diff that rejects
+// an option exits before writing to stdout, so nothing is emitted for the
+// attempts that are retried.
+func diffSideBySide(ctx context.Context, workspace, flowPath, baseDir, headDir string, width int, emit diffLineFunc) (int, error) {
+	for _, attempt := range sideBySideAttempts() {
+		cmd := buildSideBySideDiffCommand(ctx, workspace, baseDir, headDir, width, attempt)
+		diffExit, stderrText, err := runDiffCommand(cmd, sideBySideLineRewriter(flowPath, baseDir, headDir, emit))
 		if err != nil {
-			return 2, "", fmt.Errorf("generate side-by-side diff output: %w", err)
+			return 2, fmt.Errorf("generate side-by-side diff output: %w", err)
 		}
 
 		if diffExit == 2 && sideBySideOptionUnsupported(stderrText) {
 			continue
 		}
-
-		diffText = rewriteSideBySideDiffPaths(diffText, flowPath, baseDir, headDir)
-		diffText = normalizeSideBySideCommandHeaders(diffText)
-		return diffExit, diffText, nil
+		return diffExit, nil
 	}
 
-	return 2, "", fmt.Errorf("generate side-by-side diff output: diff options are not supported")
+	return 2, fmt.Errorf("generate side-by-side diff output: diff options are not supported")
 }
 
-func buildSideBySideDiffCommand(workspace, baseDir, headDir string, expandTabs bool) *exec.Cmd {
-	args := []string{
-		"--recursive",
-		"--side-by-side",
-		"--new-file",
-		fmt.Sprintf("--width=%d", sideBySideWidth),
-		fmt.Sprintf("--tabsize=%d", sideBySideTabSize),
+func diffSideBySideNative(flowPath, baseDir, headDir string, width int, emit diffLineFunc) (int, error) {
+	changed, diffText, err := sidebyside.DiffTrees(baseDir, headDir, sidebyside.Options{
+		Width:   width,
+		TabSize: sideBySideTabSize,
+	})
+	if err != nil {
+		return 2, fmt.Errorf("generate native side-by-side diff output: %w", err)
+	}
+	if !changed {
+		return 0, nil
 	}
-	if expandTabs {
-		args = append(args, "--expand-tabs")
+	emitLines(diffText, sideBySideLineRewriter(flowPath, baseDir, headDir, emit))
+	return 1, nil
+}
+
+func buildSideBySideDiffCommand(ctx context.Context, workspace, baseDir, headDir string, width int, attempt sideBySideAttempt) *exec.Cmd {
+	var args []string
+	if attempt.shortFlags {
+		args = []string{"-r", "-y", "-W", strconv.Itoa(width)}
+		if attempt.newFile {
+			args = append(args, "-N")
+		}
+		if attempt.expandTabs {
+			args = append(args, "-t")
+		}
+	} else {
+		args = []string{"--recursive", "--side-by-side"}
+		if attempt.newFile {
+			args = append(args, "--new-file")
+		}
+		args = append(args, fmt.Sprintf("--width=%d", width))
+		if attempt.tabSize {
+			args = append(args, fmt.Sprintf("--tabsize=%d", sideBySideTabSize))
+		}
+		if attempt.expandTabs {
+			args = append(args, "--expand-tabs")
+		}
 	}
 	args = append(args, baseDir, headDir)
 
-	cmd := exec.Command("diff", args...)
+	cmd := exec.CommandContext(ctx, "diff", args...)
 	cmd.Dir = workspace
 	return cmd
 }
 
-func runDiffCommand(cmd *exec.Cmd) (int, string, string, error) {
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
-	if err == nil {
-		return 0, stdout.String(), stderr.String(), nil
-	}
-	if exitErr, ok := err.(*exec.ExitError); ok {
-		return exitErr.ExitCode(), stdout.String(), stderr.String(), nil
-	}
-	return 2, "", "", err
-}
-
 func sideBySideOptionUnsupported(stderrText string) bool {
 	lower := strings.ToLower(stderrText)
 	return strings.Contains(lower, "unrecognized option") ||
 		strings.Contains(lower, "illegal option") ||
+		strings.Contains(lower, "invalid option") ||
 		strings.Contains(lower, "unknown option")
 }
 
-func normalizeSideBySideCommandHeaders(diffText string) string {
-	if diffText == "" {
-		return diffText
-	}
-	lines := strings.Split(diffText, "\n")
-	out := make([]string, 0, len(lines))
-	for _, line := range lines {
-		if strings.HasPrefix(line, "diff --recursive --side-by-side ") {
-			header := simplifySideBySideCommandHeader(line)
-			if header != "" {
-				if len(out) > 0 && out[len(out)-1] != "" {
-					out = append(out, "")
-				}
-				out = append(out, header)
-			}
-			continue
-		}
-		out = append(out, line)
-	}
-	return strings.Join(out, "\n")
+// isSideBySideCommandHeader reports whether line is a recursive diff file
+// header for either the long or short flag spelling of the command.
+func isSideBySideCommandHeader(line string) bool {
+	return strings.HasPrefix(line, "diff --recursive --side-by-side ") ||
+		strings.HasPrefix(line, "diff -r -y ")
 }
 
 func simplifySideBySideCommandHeader(line string) string {
@@ -640,53 +1914,45 @@ func simplifySideBySideCommandHeader(line string) string {
 	return "diff -- " + left + " " + right
 }
 
-func formatSideBySideDiffHTML(diffText string) string {
-	if diffText == "" {
-		return ""
-	}
-	lines := strings.Split(diffText, "\n")
-	out := make([]string, 0, len(lines))
-	for _, line := range lines {
-		out = append(out, formatSideBySideDiffHTMLLine(line))
-	}
-	return strings.Join(out, "\n")
-}
-
-func formatSideBySideDiffHTMLLine(line string) string {
+func formatSideBySideDiffHTMLLine(line string, width int, highlight bool) string {
 	if line == "" {
 		return ""
 	}
-	markerIdx, marker, ok := findSideBySideMarker(line)
+	escape := html.EscapeString
+	if highlight && !strings.HasPrefix(line, "diff -- ") {
+		escape = highlightApex
+	}
+	markerIdx, marker, ok := findSideBySideMarker(line, width)
 	if !ok {
-		return html.EscapeString(line)
+		if rightOffset := sidebyside.NewLayout(width).RightOffset; highlight && len(line) > rightOffset {
+			return escape(line[:rightOffset]) + escape(line[rightOffset:])
+		}
+		return escape(line)
 	}
 
 	switch marker {
 	case '|':
-		left := html.EscapeString(line[:markerIdx])
-		right := html.EscapeString(line[markerIdx+1:])
+		left := escape(line[:markerIdx])
+		right := escape(line[markerIdx+1:])
 		return "<span class=\"left\">" + left + "</span><span class=\"sep\">|</span><span class=\"right\">" + right + "</span>"
 	case '<':
-		leftWithMarker := html.EscapeString(line[:markerIdx+1])
-		right := html.EscapeString(line[markerIdx+1:])
+		leftWithMarker := escape(line[:markerIdx+1])
+		right := escape(line[markerIdx+1:])
 		return "<span class=\"left\">" + leftWithMarker + "</span>" + right
 	case '>':
-		left := html.EscapeString(line[:markerIdx])
-		rightWithMarker := html.EscapeString(line[markerIdx:])
+		left := escape(line[:markerIdx])
+		rightWithMarker := escape(line[markerIdx:])
 		return left + "<span class=\"right\">" + rightWithMarker + "</span>"
 	default:
-		return html.EscapeString(line)
+		return escape(line)
 	}
 }
 
-func findSideBySideMarker(line string) (int, byte, bool) {
+func findSideBySideMarker(line string, width int) (int, byte, bool) {
 	if len(line) == 0 {
 		return 0, 0, false
 	}
-	mid := (sideBySideWidth / 2) - 1
-	if mid < 0 {
-		mid = 0
-	}
+	mid := sideBySideMarkerColumn(width)
 	if mid >= len(line) {
 		return 0, 0, false
 	}
@@ -700,6 +1966,12 @@ func findSideBySideMarker(line string) (int, byte, bool) {
 	return 0, 0, false
 }
 
+// sideBySideMarkerColumn returns the zero-based column where diff places the
+// change marker for the given --width.
+func sideBySideMarkerColumn(width int) int {
+	return sidebyside.NewLayout(width).Marker
+}
+
 func isLikelySideBySideMarker(line string, idx int, marker byte) bool {
 	if idx < 0 || idx >= len(line) {
 		return false
@@ -718,29 +1990,50 @@ func isLikelySideBySideMarker(line string, idx int, marker byte) bool {
 	return next == ' ' || next == '\t'
 }
 
-func suppressCommonSideBySideDiffLines(diffText string) string {
-	if diffText == "" {
-		return diffText
-	}
-	lines := strings.Split(diffText, "\n")
-	out := make([]string, 0, len(lines))
-	for _, line := range lines {
-		if strings.HasPrefix(line, "diff -- ") {
-			out = append(out, line)
-			continue
-		}
-		if _, _, ok := findSideBySideMarker(line); ok {
-			out = append(out, line)
-		}
-	}
-	return strings.Join(out, "\n")
+// keepSideBySideCommentLine reports whether a side-by-side line belongs in
+// the comment, which shows only file headers and changed lines.
+func keepSideBySideCommentLine(line string, width int) bool {
+	if strings.HasPrefix(line, "diff -- ") {
+		return true
+	}
+	_, _, ok := findSideBySideMarker(line, width)
+	return ok
+}
+
+// writeConverterLogs writes non-empty converter stderr for each side as a
+// fenced text block.
+func writeConverterLogs(comment *strings.Builder, baseLog, headLog []byte) {
+	if len(baseLog) == 0 && len(headLog) == 0 {
+		return
+	}
+	comment.WriteString("```text\n")
+	if len(baseLog) > 0 {
+		comment.WriteString("[base]\n")
+		comment.Write(truncateBytes(baseLog, maxErrorChars))
+		comment.WriteString("\n")
+	}
+	if len(headLog) > 0 {
+		comment.WriteString("[head]\n")
+		comment.Write(truncateBytes(headLog, maxErrorChars))
+		comment.WriteString("\n")
+	}
+	comment.WriteString("```\n\n")
 }
 
-func truncateDiff(diffText string) string {
-	if len(diffText) <= maxDiffChars {
-		return diffText
+func unifiedDiffFileName(header, flowPath, baseDir, headDir string) string {
+	path := strings.TrimPrefix(header, "diff --git ")
+	if idx := strings.Index(path, " b/"+flowPath+"/"); idx >= 0 {
+		path = path[idx+len(" b/"+flowPath+"/"):]
+	} else if fields := strings.Fields(path); len(fields) > 0 {
+		path = fields[len(fields)-1]
+	}
+	for _, dir := range []string{headDir, baseDir} {
+		prefix := strings.TrimPrefix(filepath.ToSlash(dir), "/") + "/"
+		if strings.HasPrefix(path, prefix) {
+			return strings.TrimPrefix(path, prefix)
+		}
 	}
-	return diffText[:maxDiffChars] + "\n...diff truncated..."
+	return path
 }
 
 func truncateBytes(data []byte, max int) []byte {
@@ -761,6 +2054,137 @@ func sanitizeFlowPath(flowPath string) string {
 	return replacer.Replace(flowPath)
 }
 
+// streamWriter buffers string writes to a file. When limit is positive, the
+// write that would pass limit bytes is cut back to its last complete line,
+// everything after it is dropped, and truncated is set. The first write error
+// is kept and returned by Close, so callers can write freely and check once.
+type streamWriter struct {
+	path      string
+	f         *os.File
+	w         *bufio.Writer
+	limit     int
+	written   int
+	truncated bool
+	err       error
+}
+
+func createStreamWriter(path string, limit int) (*streamWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &streamWriter{path: path, f: f, w: bufio.NewWriter(f), limit: limit}, nil
+}
+
+func (s *streamWriter) WriteString(str string) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n := len(str)
+	if s.limit > 0 {
+		if s.truncated {
+			return n, nil
+		}
+		if s.written+len(str) > s.limit {
+			// Cut at a line boundary so no line, or UTF-8 sequence, is
+			// split.
+			str = str[:s.limit-s.written]
+			if i := strings.LastIndexByte(str, '\n'); i >= 0 {
+				str = str[:i+1]
+			} else {
+				str = ""
+			}
+			s.truncated = true
+		}
+	}
+	written, err := s.w.WriteString(str)
+	s.written += written
+	s.err = err
+	return n, err
+}
+
+// Close flushes and closes the file. Calling it again is a no-op.
+func (s *streamWriter) Close() error {
+	if s.f == nil {
+		return s.err
+	}
+	if err := s.w.Flush(); err != nil && s.err == nil {
+		s.err = err
+	}
+	if err := s.f.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	s.f = nil
+	return s.err
+}
+
+// runSummary is written as JSON to --summary-file so CI can gate on counts
+// without parsing the markdown comment.
+type runSummary struct {
+	TotalFlows             int  `json:"total_flows"`
+	ChangedFlows           int  `json:"changed_flows"`
+	UnchangedFlows         int  `json:"unchanged_flows"`
+	DiffFailures           int  `json:"diff_failures"`
+	BaseConversionFailures int  `json:"base_conversion_failures"`
+	HeadConversionFailures int  `json:"head_conversion_failures"`
+	OmittedFlows           int  `json:"omitted_flows"`
+	Truncated              bool `json:"truncated"`
+}
+
+// hasFlowChanges reports whether some flow's generated Apex differs. Flows
+// with only conversion issues or metadata edits are not changes.
+func hasFlowChanges(summary runSummary) bool {
+	return summary.ChangedFlows > 0
+}
+
+// exitStatus returns the process exit code for a finished run. Without
+// --exit-code a finished run always succeeds; with it, a flow whose renders
+// could not be diffed is a failure rather than a change.
+func exitStatus(summary runSummary, exitCode bool) int {
+	switch {
+	case !exitCode:
+		return exitNoChanges
+	case summary.DiffFailures > 0:
+		return exitFailure
+	case hasFlowChanges(summary):
+		return exitChanges
+	}
+	return exitNoChanges
+}
+
+// add counts one flow's result and returns its report entry status.
+func (s *runSummary) add(result flowResult) string {
+	if result.baseStatus == renderFailed || result.baseStatus == renderTimedOut {
+		s.BaseConversionFailures++
+	}
+	if result.headStatus == renderFailed || result.headStatus == renderTimedOut {
+		s.HeadConversionFailures++
+	}
+	s.Truncated = result.truncated || s.Truncated
+	switch result.diffExit {
+	case 1:
+		s.ChangedFlows++
+		return reportChanged
+	case 0:
+		s.UnchangedFlows++
+		return reportUnchanged
+	default:
+		s.DiffFailures++
+		return reportFailed
+	}
+}
+
+func writeSummary(path string, summary runSummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode summary: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write summary file: %w", err)
+	}
+	return nil
+}
+
 type outputKV struct {
 	Key   string
 	Value string
@@ -1,8 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestFindSideBySideMarker_OnlyUsesSeparatorColumn(t *testing.T) {
@@ -14,7 +23,7 @@ func TestFindSideBySideMarker_OnlyUsesSeparatorColumn(t *testing.T) {
 	b[(sideBySideWidth/2)-3] = ' '
 	b[(sideBySideWidth/2)-1] = ' '
 
-	if _, _, ok := findSideBySideMarker(string(b)); ok {
+	if _, _, ok := findSideBySideMarker(string(b), sideBySideWidth); ok {
 		t.Fatalf("expected no marker when separator column has no marker")
 	}
 }
@@ -27,7 +36,7 @@ func TestFindSideBySideMarker_DetectsColumnMarker(t *testing.T) {
 	b[mid] = '|'
 	b[mid+1] = ' '
 
-	idx, marker, ok := findSideBySideMarker(string(b))
+	idx, marker, ok := findSideBySideMarker(string(b), sideBySideWidth)
 	if !ok {
 		t.Fatalf("expected marker to be detected")
 	}
@@ -36,7 +45,69 @@ func TestFindSideBySideMarker_DetectsColumnMarker(t *testing.T) {
 	}
 }
 
-func TestSuppressCommonSideBySideDiffLines(t *testing.T) {
+func TestFindSideBySideMarker_UsesConfiguredWidth(t *testing.T) {
+	width := 131
+	b := []byte(strings.Repeat("x", width))
+	mid := sideBySideMarkerColumn(width)
+	b[mid-1] = ' '
+	b[mid] = '>'
+	b[mid+1] = ' '
+
+	idx, marker, ok := findSideBySideMarker(string(b), width)
+	if !ok {
+		t.Fatalf("expected marker to be detected")
+	}
+	if idx != mid || marker != '>' {
+		t.Fatalf("unexpected marker result: idx=%d marker=%q", idx, marker)
+	}
+	if _, _, ok := findSideBySideMarker(string(b), sideBySideWidth); ok {
+		t.Fatalf("expected no marker when scanning with a different width")
+	}
+}
+
+func TestSideBySideMarkerColumn(t *testing.T) {
+	// Expected columns were captured from GNU diff --side-by-side --expand-tabs.
+	cases := map[int]int{
+		80:  39,
+		130: 64,
+		131: 65,
+		200: 99,
+	}
+	for width, want := range cases {
+		if got := sideBySideMarkerColumn(width); got != want {
+			t.Fatalf("sideBySideMarkerColumn(%d) = %d, want %d", width, got, want)
+		}
+	}
+}
+
+func TestNormalizeSideBySideWidth(t *testing.T) {
+	if got, err := normalizeSideBySideWidth(""); err != nil || got != sideBySideWidth {
+		t.Fatalf("expected default width, got %d (err=%v)", got, err)
+	}
+	if got, err := normalizeSideBySideWidth(" 240 "); err != nil || got != 240 {
+		t.Fatalf("expected width 240, got %d (err=%v)", got, err)
+	}
+	if _, err := normalizeSideBySideWidth("wide"); err == nil {
+		t.Fatalf("expected error for non-numeric width")
+	}
+	if _, err := normalizeSideBySideWidth("10"); err == nil {
+		t.Fatalf("expected error for width below minimum")
+	}
+}
+
+func TestNormalizeCommentFence(t *testing.T) {
+	if got, err := normalizeCommentFence(""); err != nil || got != commentFenceDiff {
+		t.Fatalf("expected default diff fence, got %q (err=%v)", got, err)
+	}
+	if got, err := normalizeCommentFence(" TEXT "); err != nil || got != commentFenceText {
+		t.Fatalf("expected text fence, got %q (err=%v)", got, err)
+	}
+	if _, err := normalizeCommentFence("apex"); err == nil {
+		t.Fatalf("expected error for unknown fence")
+	}
+}
+
+func TestKeepSideBySideCommentLine(t *testing.T) {
 	common := strings.Repeat("a", sideBySideWidth)
 	changed := strings.Repeat("b", sideBySideWidth)
 
@@ -48,31 +119,1249 @@ func TestSuppressCommonSideBySideDiffLines(t *testing.T) {
 	changed = string(b)
 
 	header := "diff -- a/flow/meta.xml/generated-1.apex b/flow/meta.xml/generated-1.apex"
-	got := suppressCommonSideBySideDiffLines(header + "\n" + common + "\n" + changed + "\n")
-	if !strings.Contains(got, header) {
+	if !keepSideBySideCommentLine(header, sideBySideWidth) {
 		t.Fatalf("expected diff header to be retained")
 	}
-	if strings.Contains(got, common) {
+	if keepSideBySideCommentLine(common, sideBySideWidth) {
 		t.Fatalf("expected common line to be removed")
 	}
-	if !strings.Contains(got, changed) {
+	if !keepSideBySideCommentLine(changed, sideBySideWidth) {
 		t.Fatalf("expected changed line to be retained")
 	}
 }
 
-func TestNormalizeSideBySideCommandHeaders(t *testing.T) {
-	input := strings.Join([]string{
-		"diff --recursive --side-by-side --new-file --width=200 --tabsize=3 --expand-tabs a/flow/meta.xml/one.apex b/flow/meta.xml/one.apex",
+// collectLines returns a diffLineFunc that appends to lines.
+func collectLines(lines *[]string) diffLineFunc {
+	return func(line string) { *lines = append(*lines, line) }
+}
+
+func TestSideBySideLineRewriter(t *testing.T) {
+	var lines []string
+	emit := sideBySideLineRewriter("flow/meta.xml", "/tmp/base", "/tmp/head", collectLines(&lines))
+	for _, line := range []string{
+		"diff --recursive --side-by-side --new-file --width=200 --tabsize=3 --expand-tabs /tmp/base/one.apex /tmp/head/one.apex",
+		"left line | right line",
+		"diff --recursive --side-by-side --new-file --width=200 --tabsize=3 --expand-tabs /tmp/base/two.apex /tmp/head/two.apex",
+		"left line 2 | right line 2",
+	} {
+		emit(line)
+	}
+
+	want := []string{
+		"diff -- a/flow/meta.xml/one.apex b/flow/meta.xml/one.apex",
 		"left line | right line",
-		"diff --recursive --side-by-side --new-file --width=200 --tabsize=3 --expand-tabs a/flow/meta.xml/two.apex b/flow/meta.xml/two.apex",
+		"",
+		"diff -- a/flow/meta.xml/two.apex b/flow/meta.xml/two.apex",
 		"left line 2 | right line 2",
-	}, "\n")
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected lines:\n%q\nwant:\n%q", lines, want)
+	}
+}
+
+func TestSideBySideLineRewriter_ShortFlags(t *testing.T) {
+	var lines []string
+	emit := sideBySideLineRewriter("flow/meta.xml", "/tmp/base", "/tmp/head", collectLines(&lines))
+	emit("diff -r -y -W 200 -N -t /tmp/base/one.apex /tmp/head/one.apex")
+	emit("left line | right line")
+
+	if len(lines) != 2 || lines[0] != "diff -- a/flow/meta.xml/one.apex b/flow/meta.xml/one.apex" {
+		t.Fatalf("expected simplified diff header, got %q", lines)
+	}
+}
+
+// writeStubScript writes body as an executable POSIX shell script named name
+// in dir and returns its path. Tests that need it skip on Windows.
+func writeStubScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stub " + name + " requires a POSIX shell")
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatalf("write stub %s: %v", name, err)
+	}
+	return path
+}
+
+// prependPath puts dir first on PATH for the rest of the test.
+func prependPath(t *testing.T, dir string) {
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// stubDiffScript is a fake diff that rejects any argument matching one of the
+// given shell patterns and otherwise echoes its arguments.
+func stubDiffScript(rejected ...string) string {
+	var script strings.Builder
+	script.WriteString("for arg in \"$@\"; do\n")
+	script.WriteString("  case \"$arg\" in\n")
+	for _, pattern := range rejected {
+		script.WriteString("    " + pattern + ") echo \"diff: unrecognized option '$arg'\" >&2; exit 2;;\n")
+	}
+	script.WriteString("  esac\n")
+	script.WriteString("done\n")
+	script.WriteString("echo \"$@\"\n")
+	script.WriteString("exit 1\n")
+	return script.String()
+}
+
+func TestDiffSideBySide_DropsUnsupportedLongOptions(t *testing.T) {
+	stubDir := t.TempDir()
+	writeStubScript(t, stubDir, "diff", stubDiffScript("--expand-tabs", "--tabsize=*"))
+	prependPath(t, stubDir)
+	workspace := t.TempDir()
+
+	var lines []string
+	exit, err := diffSideBySide(context.Background(), workspace, "flows/X.flow-meta.xml", "base", "head", sideBySideWidth, collectLines(&lines))
+	got := strings.Join(lines, "\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exit != 1 {
+		t.Fatalf("expected exit 1, got %d", exit)
+	}
+	if !strings.Contains(got, "--new-file") || !strings.Contains(got, "--width=200") {
+		t.Fatalf("expected remaining long options to be kept, got %q", got)
+	}
+	if strings.Contains(got, "--tabsize") || strings.Contains(got, "--expand-tabs") {
+		t.Fatalf("expected unsupported options to be dropped, got %q", got)
+	}
+}
+
+func TestDiffSideBySide_FallsBackToShortFlags(t *testing.T) {
+	stubDir := t.TempDir()
+	writeStubScript(t, stubDir, "diff", stubDiffScript("--*"))
+	prependPath(t, stubDir)
+	workspace := t.TempDir()
+
+	var lines []string
+	_, err := diffSideBySide(context.Background(), workspace, "flows/X.flow-meta.xml", "base", "head", sideBySideWidth, collectLines(&lines))
+	got := strings.Join(lines, "\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(got, "-r -y -W 200 -N -t ") {
+		t.Fatalf("expected short flag invocation, got %q", got)
+	}
+}
+
+func TestDiffSideBySide_FallsBackWithoutNewFile(t *testing.T) {
+	stubDir := t.TempDir()
+	writeStubScript(t, stubDir, "diff", stubDiffScript("--*", "-t", "-N"))
+	prependPath(t, stubDir)
+	workspace := t.TempDir()
+
+	var lines []string
+	_, err := diffSideBySide(context.Background(), workspace, "flows/X.flow-meta.xml", "base", "head", sideBySideWidth, collectLines(&lines))
+	got := strings.Join(lines, "\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(got, "-r -y -W 200 a/") {
+		t.Fatalf("expected minimal short flag invocation, got %q", got)
+	}
+}
+
+func TestDiffSideBySide_AllOptionsUnsupported(t *testing.T) {
+	stubDir := t.TempDir()
+	writeStubScript(t, stubDir, "diff", stubDiffScript("-*"))
+	prependPath(t, stubDir)
+	workspace := t.TempDir()
+
+	if _, err := diffSideBySide(context.Background(), workspace, "flows/X.flow-meta.xml", "base", "head", sideBySideWidth, func(string) {}); err == nil {
+		t.Fatalf("expected error when no attempt is supported")
+	}
+}
+
+func TestDiffSideBySideNative(t *testing.T) {
+	baseDir := t.TempDir()
+	headDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(baseDir, "One.cls"), []byte("same\nold\n"), 0o644); err != nil {
+		t.Fatalf("write base file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(headDir, "One.cls"), []byte("same\nnew\n"), 0o644); err != nil {
+		t.Fatalf("write head file: %v", err)
+	}
+
+	var lines []string
+	exit, err := diffSideBySideNative("flows/X.flow-meta.xml", baseDir, headDir, sideBySideWidth, collectLines(&lines))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exit != 1 {
+		t.Fatalf("expected exit 1, got %d", exit)
+	}
+	header := "diff -- a/flows/X.flow-meta.xml/One.cls b/flows/X.flow-meta.xml/One.cls"
+	var kept []string
+	for _, line := range lines {
+		if keepSideBySideCommentLine(line, sideBySideWidth) {
+			kept = append(kept, line)
+		}
+	}
+	suppressed := strings.Join(kept, "\n")
+	if !strings.Contains(suppressed, header) {
+		t.Fatalf("expected rewritten header, got %q", suppressed)
+	}
+	if strings.Contains(suppressed, "same") || !strings.Contains(suppressed, "new") {
+		t.Fatalf("expected only the changed line to survive suppression, got %q", suppressed)
+	}
+}
+
+// newTestFlowDiffWriter returns a unified flowDiffWriter for flows/X.flow
+// whose comment is written to a file in a temp dir.
+func newTestFlowDiffWriter(t *testing.T, flat bool) (*flowDiffWriter, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "comment.md")
+	comment, err := createStreamWriter(path, 0)
+	if err != nil {
+		t.Fatalf("create comment: %v", err)
+	}
+	t.Cleanup(func() { comment.Close() })
+	return &flowDiffWriter{
+		flowPath: "flows/X.flow",
+		baseDir:  "/tmp/base",
+		headDir:  "/tmp/head",
+		format:   diffFormatUnified,
+		fence:    commentFenceDiff,
+		flat:     flat,
+		comment:  comment,
+	}, path
+}
+
+func TestFlowDiffWriter_SplitsUnifiedDiffByFile(t *testing.T) {
+	out, path := newTestFlowDiffWriter(t, false)
+	for _, line := range []string{
+		"diff --git a/flows/X.flow/tmp/base/One.cls b/flows/X.flow/tmp/head/One.cls",
+		"--- a/flows/X.flow/tmp/base/One.cls",
+		"+++ b/flows/X.flow/tmp/head/One.cls",
+		"@@ -1 +1 @@",
+		"-old",
+		"+new",
+		"diff --git a/flows/X.flow/tmp/base/sub/Gone.cls b/flows/X.flow/tmp/base/sub/Gone.cls",
+		"deleted file mode 100644",
+		"--- a/flows/X.flow/tmp/base/sub/Gone.cls",
+		"+++ /dev/null",
+		"@@ -1 +0,0 @@",
+		"-gone",
+	} {
+		out.writeLine(line)
+	}
+	if truncated, err := out.close(true); err != nil || truncated {
+		t.Fatalf("unexpected close result truncated=%v err=%v", truncated, err)
+	}
+	if err := out.comment.Close(); err != nil {
+		t.Fatalf("close comment: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read comment: %v", err)
+	}
+	one := strings.Index(string(got), "#### `One.cls`\n\n```diff\ndiff --git ")
+	gone := strings.Index(string(got), "#### `sub/Gone.cls`\n\n```diff\ndiff --git ")
+	if one < 0 || gone < one {
+		t.Fatalf("expected a block per file, got:\n%s", got)
+	}
+	if !strings.Contains(string(got)[one:gone], "+new") || strings.Contains(string(got)[one:gone], "-gone") {
+		t.Fatalf("unexpected first file block:\n%s", got)
+	}
+	if !strings.HasSuffix(string(got), "-gone\n```\n\n") {
+		t.Fatalf("expected the last block to be closed, got:\n%s", got)
+	}
+}
+
+func TestHighlightApex(t *testing.T) {
+	got := highlightApex(`if (a < b) { s = 'x<y'; } // done & dusted`)
+	want := `<span class="kw">if</span> (a &lt; b) { s = <span class="str">&#39;x&lt;y&#39;</span>; } <span class="com">// done &amp; dusted</span>`
+	if got != want {
+		t.Fatalf("unexpected highlight:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHighlightApex_UnterminatedTokens(t *testing.T) {
+	if got := highlightApex(`x = 'it\'s`); got != `x = <span class="str">&#39;it\&#39;s</span>` {
+		t.Fatalf("unexpected unterminated string highlight: %s", got)
+	}
+	if got := highlightApex("/* open <comment"); got != `<span class="com">/* open &lt;comment</span>` {
+		t.Fatalf("unexpected unterminated comment highlight: %s", got)
+	}
+	if got := highlightApex("Public Forever"); got != `<span class="kw">Public</span> Forever` {
+		t.Fatalf("expected case-insensitive keyword match, got %s", got)
+	}
+}
+
+func TestFormatSideBySideDiffHTMLLine_Highlight(t *testing.T) {
+	b := []byte(strings.Repeat(" ", sideBySideWidth))
+	mid := sideBySideMarkerColumn(sideBySideWidth)
+	copy(b, "return null;")
+	b[mid] = '|'
+	copy(b[mid+2:], "return 'x';")
+	line := strings.TrimRight(string(b), " ")
+
+	got := formatSideBySideDiffHTMLLine(line, sideBySideWidth, true)
+	if !strings.HasPrefix(got, `<span class="left"><span class="kw">return</span> <span class="kw">null</span>;`) {
+		t.Fatalf("expected highlighted left side, got %s", got)
+	}
+	if !strings.Contains(got, `<span class="right"> <span class="kw">return</span> <span class="str">&#39;x&#39;</span>;</span>`) {
+		t.Fatalf("expected highlighted right side, got %s", got)
+	}
+	if plain := formatSideBySideDiffHTMLLine(line, sideBySideWidth, false); strings.Contains(plain, `class="kw"`) {
+		t.Fatalf("expected no highlighting when disabled, got %s", plain)
+	}
+}
+
+func TestWriteDryRunPlan(t *testing.T) {
+	var out bytes.Buffer
+	opts := diffOptions{format: diffFormatUnified, width: sideBySideWidth}
+	writeDryRunPlan(&out, "/repo", []string{"flows/A.flow-meta.xml"}, filepath.Join("<tmp>", "base-checkout"), filepath.Join("<tmp>", "head-checkout"), "", "/bin/flow2apex", nil, opts)
+
+	got := out.String()
+	for _, want := range []string{
+		"Detected 1 changed flow file(s):\n  flows/A.flow-meta.xml\n",
+		"flow2apex binary: /bin/flow2apex\n",
+		"render base: /bin/flow2apex " + filepath.Join("<tmp>", "base-checkout", "flows", "A.flow-meta.xml") + " -d " + filepath.Join("<tmp>", "base-render-flows_A.flow-meta.xml"),
+		"diff: git diff --no-index --src-prefix=a/flows/A.flow-meta.xml/ --dst-prefix=b/flows/A.flow-meta.xml/ -- ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in dry-run output:\n%s", want, got)
+		}
+	}
+}
+
+func TestWriteDryRunPlan_UnresolvedBinary(t *testing.T) {
+	var out bytes.Buffer
+	opts := diffOptions{format: diffFormatSideBySide, width: sideBySideWidth, native: true}
+	writeDryRunPlan(&out, "/repo", []string{"flows/A.flow"}, filepath.Join("<tmp>", "base-checkout"), filepath.Join("<tmp>", "head-checkout"), "", "", errors.New("flow2apex binary not found on PATH"), opts)
+
+	got := out.String()
+	if !strings.Contains(got, "flow2apex binary: unresolved (flow2apex binary not found on PATH)") {
+		t.Fatalf("expected unresolved binary note:\n%s", got)
+	}
+	if !strings.Contains(got, "diff: built-in side-by-side differ (width 200)") {
+		t.Fatalf("expected native differ description:\n%s", got)
+	}
+}
+
+func TestRenderFlow(t *testing.T) {
+	converter := "if [ \"$2\" = \"-d\" ]; then echo 'public class A {}' > \"$3/A.cls\"; fi\n" +
+		"echo 'converter log' >&2\n"
+	tests := []struct {
+		name        string
+		script      string
+		flowPath    string
+		timeout     time.Duration
+		runDeadline time.Duration
+		wantStatus  int
+		wantLog     string
+		wantErr     error
+	}{
+		{name: "converted", script: converter + "exit 0\n", wantStatus: renderOK, wantLog: "converter log"},
+		{name: "failed", script: converter + "exit 1\n", wantStatus: renderFailed, wantLog: "converter log"},
+		{name: "unsupported", script: converter + "exit " + strconv.Itoa(flow2apexExitUnsupported) + "\n", wantStatus: renderUnsupported, wantLog: "converter log"},
+		{name: "missing flow", flowPath: "missing.flow-meta.xml", wantStatus: renderMissing},
+		{name: "traversal", flowPath: "../Outside.flow-meta.xml", wantStatus: renderFailed, wantLog: "stay inside"},
+		{name: "timed out", script: "exec sleep 10\n", timeout: 100 * time.Millisecond, wantStatus: renderTimedOut},
+		{name: "run canceled", script: "exec sleep 10\n", timeout: time.Minute, runDeadline: 100 * time.Millisecond, wantErr: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		root := t.TempDir()
+		checkout := filepath.Join(root, "checkout")
+		if err := os.MkdirAll(checkout, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{filepath.Join(checkout, "A.flow-meta.xml"), filepath.Join(root, "Outside.flow-meta.xml")} {
+			if err := os.WriteFile(path, []byte("<Flow/>"), 0o644); err != nil {
+				t.Fatalf("write flow: %v", err)
+			}
+		}
+		bin := "flow2apex"
+		if tt.script != "" {
+			bin = writeStubScript(t, t.TempDir(), "flow2apex", tt.script)
+		}
+		flowPath := tt.flowPath
+		if flowPath == "" {
+			flowPath = "A.flow-meta.xml"
+		}
+		ctx := context.Background()
+		if tt.runDeadline > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tt.runDeadline)
+			defer cancel()
+		}
+
+		start := time.Now()
+		status, log, err := renderFlow(ctx, checkout, bin, flowPath, t.TempDir(), tt.timeout)
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("%s: expected render to be killed promptly, took %s", tt.name, elapsed)
+		}
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if status != tt.wantStatus {
+			t.Fatalf("%s: expected status %d, got %d", tt.name, tt.wantStatus, status)
+		}
+		if !strings.Contains(string(log), tt.wantLog) {
+			t.Fatalf("%s: expected %q in log, got %q", tt.name, tt.wantLog, log)
+		}
+	}
+}
+
+func TestFlowIgnore(t *testing.T) {
+	workspace := t.TempDir()
+	ignoreFile := "# generated flows\n" +
+		"vendor/\n" +
+		"*_Generated.flow-meta.xml\n" +
+		"/force-app/legacy/**\n" +
+		"!force-app/legacy/Keep.flow-meta.xml\n"
+	if err := os.WriteFile(filepath.Join(workspace, flowIgnoreFile), []byte(ignoreFile), 0o644); err != nil {
+		t.Fatalf("write ignore file: %v", err)
+	}
+	ignore, err := loadFlowIgnore(workspace, []string{"**/sandbox/*.flow"})
+	if err != nil {
+		t.Fatalf("load ignore: %v", err)
+	}
+
+	tests := map[string]bool{
+		"force-app/main/default/flows/A.flow-meta.xml":           false,
+		"vendor/flows/A.flow-meta.xml":                           true,
+		"pkg/vendor/flows/A.flow-meta.xml":                       true,
+		"force-app/flows/Lead_Generated.flow-meta.xml":           true,
+		"force-app/legacy/Old.flow-meta.xml":                     true,
+		"force-app/legacy/Keep.flow-meta.xml":                    false,
+		"other/force-app/legacy/Old.flow-meta.xml":               false,
+		"force-app/sandbox/Test.flow":                            true,
+		"force-app/sandbox/nested/Test.flow":                     false,
+		"force-app/main/default/flows/vendor.flow-meta.xml":      false,
+		"force-app/main/default/flows/Lead_Generated.flow":       false,
+		"force-app/main/default/flows/x_Generated.flow-meta.xml": true,
+	}
+	for path, want := range tests {
+		if got := ignore.Ignored(path); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestFlowIgnore_MissingFile(t *testing.T) {
+	ignore, err := loadFlowIgnore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("load ignore: %v", err)
+	}
+	flows := []string{"a.flow", "b.flow-meta.xml"}
+	if got := filterIgnoredFlows(flows, ignore); len(got) != len(flows) {
+		t.Fatalf("expected no flows filtered, got %v", got)
+	}
+}
+
+// initTestRepo creates a git repository with the given files committed and
+// returns its path and the commit sha.
+func initTestRepo(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "init")
+	return dir, git("rev-parse", "HEAD")
+}
+
+func TestExtractFlowsFromArchive(t *testing.T) {
+	workspace, sha := initTestRepo(t, map[string]string{
+		"flows/A.flow-meta.xml": "<Flow>A</Flow>",
+		"flows/B.flow-meta.xml": "<Flow>B</Flow>",
+		"README.md":             "readme",
+	})
+	dir := filepath.Join(t.TempDir(), "checkout")
+
+	err := extractFlowsFromArchive(context.Background(), workspace, sha, dir, []string{"flows/A.flow-meta.xml", "flows/Missing.flow-meta.xml"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "flows", "A.flow-meta.xml"))
+	if err != nil {
+		t.Fatalf("read extracted flow: %v", err)
+	}
+	if string(got) != "<Flow>A</Flow>" {
+		t.Fatalf("unexpected flow content %q", got)
+	}
+	for _, name := range []string{"flows/B.flow-meta.xml", "flows/Missing.flow-meta.xml", "README.md"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Fatalf("expected %s not to be extracted, stat err %v", name, err)
+		}
+	}
+}
+
+func TestPrepareCheckout_FallsBackToArchive(t *testing.T) {
+	workspace, sha := initTestRepo(t, map[string]string{
+		"flows/A.flow-meta.xml": "<Flow>A</Flow>",
+	})
+	dir := filepath.Join(t.TempDir(), "checkout")
+	// A non-empty target directory makes git worktree add fail.
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "stale"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write stale file: %v", err)
+	}
+
+	cleanup, err := prepareCheckout(context.Background(), workspace, sha, dir, []string{"flows/A.flow-meta.xml"}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cleanup(); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "flows", "A.flow-meta.xml")); err != nil {
+		t.Fatalf("expected flow to be extracted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "stale")); !os.IsNotExist(err) {
+		t.Fatalf("expected stale file to be removed, stat err %v", err)
+	}
+}
+
+func TestPrepareCheckout_Sparse(t *testing.T) {
+	workspace, sha := initTestRepo(t, map[string]string{
+		"flows/A.flow-meta.xml": "<Flow>A</Flow>",
+		"flows/A.flow":          "<Flow>A</Flow>",
+		"flows/B.flow-meta.xml": "<Flow>B</Flow>",
+		"src/Other.cls":         "class Other {}",
+	})
+	if !gitSupportsSparseCheckout(workspace) {
+		t.Skip("git does not support sparse worktrees")
+	}
+	dir := filepath.Join(t.TempDir(), "checkout")
+
+	cleanup, err := prepareCheckout(context.Background(), workspace, sha, dir, []string{"flows/A.flow-meta.xml"}, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"flows/A.flow-meta.xml", "flows/A.flow"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Fatalf("expected %s to be checked out: %v", name, err)
+		}
+	}
+	for _, name := range []string{"flows/B.flow-meta.xml", "src/Other.cls"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Fatalf("expected %s not to be checked out, stat err %v", name, err)
+		}
+	}
+	if err := cleanup(); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected worktree to be removed, stat err %v", err)
+	}
+	cmd := exec.Command("git", "config", "--local", "--list")
+	cmd.Dir = workspace
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git config: %v", err)
+	}
+	if strings.Contains(strings.ToLower(string(out)), "worktreeconfig") || strings.Contains(strings.ToLower(string(out)), "sparsecheckout") {
+		t.Fatalf("expected the workspace config to be left alone, got:\n%s", out)
+	}
+}
+
+func TestSparseCheckoutPatterns(t *testing.T) {
+	got := sparseCheckoutPatterns([]string{"flows/A.flow-meta.xml", "flows/A.flow", "flows/My Flow[1].flow"})
+	want := []string{
+		"/flows/A.flow-meta.xml",
+		"/flows/A.flow",
+		`/flows/My\ Flow\[1].flow`,
+		`/flows/My\ Flow\[1].flow-meta.xml`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected patterns:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestParseGitVersion(t *testing.T) {
+	tests := []struct {
+		output       string
+		major, minor int
+		ok           bool
+	}{
+		{output: "git version 2.39.5\n", major: 2, minor: 39, ok: true},
+		{output: "git version 2.39.3 (Apple Git-146)\n", major: 2, minor: 39, ok: true},
+		{output: "git version 2.45.1.windows.1\n", major: 2, minor: 45, ok: true},
+		{output: "not git\n", ok: false},
+	}
+	for _, tt := range tests {
+		major, minor, ok := parseGitVersion(tt.output)
+		if ok != tt.ok || major != tt.major || minor != tt.minor {
+			t.Fatalf("parseGitVersion(%q) = %d, %d, %v", tt.output, major, minor, ok)
+		}
+	}
+}
+
+func TestDiscardWorktree_RemovesLockedWorktree(t *testing.T) {
+	workspace, sha := initTestRepo(t, map[string]string{
+		"flows/A.flow-meta.xml": "<Flow>A</Flow>",
+	})
+	dir := filepath.Join(t.TempDir(), "checkout")
+	for _, args := range [][]string{{"worktree", "add", "--detach", dir, sha}, {"worktree", "lock", dir}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = workspace
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	if err := discardWorktree(workspace, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cmd := exec.Command("git", "worktree", "list", "--porcelain")
+	cmd.Dir = workspace
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git worktree list: %v", err)
+	}
+	if strings.Count(string(out), "worktree ") != 1 {
+		t.Fatalf("expected only the main worktree, got:\n%s", out)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected checkout dir to be removed, stat err %v", err)
+	}
+}
+
+func TestPrepareCheckout_CanceledSkipsFallback(t *testing.T) {
+	workspace, sha := initTestRepo(t, map[string]string{
+		"flows/A.flow-meta.xml": "<Flow>A</Flow>",
+	})
+	dir := filepath.Join(t.TempDir(), "checkout")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := prepareCheckout(ctx, workspace, sha, dir, []string{"flows/A.flow-meta.xml"}, false); err == nil {
+		t.Fatalf("expected error for a canceled run")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "flows", "A.flow-meta.xml")); !os.IsNotExist(err) {
+		t.Fatalf("expected no archive fallback after cancellation, stat err %v", err)
+	}
+}
+
+func TestNormalizeRenderTimeout(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":      defaultRenderTimeout,
+		"90s":   90 * time.Second,
+		"2m":    2 * time.Minute,
+		"45":    45 * time.Second,
+		" 0 ":   0,
+		"500ms": 500 * time.Millisecond,
+	}
+	for value, want := range tests {
+		got, err := normalizeRenderTimeout(value)
+		if err != nil {
+			t.Fatalf("normalizeRenderTimeout(%q): unexpected error: %v", value, err)
+		}
+		if got != want {
+			t.Fatalf("normalizeRenderTimeout(%q) = %s, want %s", value, got, want)
+		}
+	}
+	for _, value := range []string{"soon", "-5s"} {
+		if _, err := normalizeRenderTimeout(value); err == nil {
+			t.Fatalf("normalizeRenderTimeout(%q): expected error", value)
+		}
+	}
+}
+
+func TestWriteConverterLogs(t *testing.T) {
+	var comment strings.Builder
+	writeConverterLogs(&comment, nil, []byte("warning: TODO emitted for Wait element"))
+	want := "```text\n[head]\nwarning: TODO emitted for Wait element\n```\n\n"
+	if comment.String() != want {
+		t.Fatalf("unexpected logs block:\n%s", comment.String())
+	}
+
+	comment.Reset()
+	writeConverterLogs(&comment, nil, nil)
+	if comment.Len() != 0 {
+		t.Fatalf("expected no output for empty logs, got %q", comment.String())
+	}
+}
+
+func TestSideBySideHTMLTOC(t *testing.T) {
+	got := sideBySideHTMLTOC([]reportEntry{
+		{FlowPath: "flows/A.flow-meta.xml", Status: reportChanged},
+		{FlowPath: "flows/B & C.flow", Status: reportUnchanged},
+	})
+	want := "    <nav class=\"toc\">\n      <ul>\n" +
+		"        <li><a href=\"#flow-flows_A.flow-meta.xml\">flows/A.flow-meta.xml</a><span class=\"toc-status changed\">changed</span></li>\n" +
+		"        <li><a href=\"#flow-flows_B_&amp;_C.flow\">flows/B &amp; C.flow</a><span class=\"toc-status unchanged\">unchanged</span></li>\n" +
+		"      </ul>\n    </nav>\n"
+	if got != want {
+		t.Fatalf("unexpected toc:\n%s", got)
+	}
+	if sideBySideHTMLTOC(nil) != "" {
+		t.Fatalf("expected no toc without entries")
+	}
+
+	var heading strings.Builder
+	writeSideBySideHTMLHeading(&heading, "flows/A.flow-meta.xml")
+	if heading.String() != "    <h2 id=\"flow-flows_A.flow-meta.xml\">flows/A.flow-meta.xml</h2>\n" {
+		t.Fatalf("unexpected heading %q", heading.String())
+	}
+}
+
+func TestStartSideBySideHTMLReport_Scale(t *testing.T) {
+	scaled := startSideBySideHTMLReport("base", "head", sideBySideWidth, true)
+	for _, want := range []string{"function fitSideBySideDiffs()", "id=\"reset-zoom\"", "prefers-reduced-motion: reduce"} {
+		if !strings.Contains(scaled, want) {
+			t.Fatalf("expected scaled report to contain %q", want)
+		}
+	}
+
+	unscaled := startSideBySideHTMLReport("base", "head", sideBySideWidth, false)
+	for _, unwanted := range []string{"<script>", "reset-zoom\" type"} {
+		if strings.Contains(unscaled, unwanted) {
+			t.Fatalf("expected unscaled report not to contain %q", unwanted)
+		}
+	}
+}
+
+func TestDetectChangedFlowsInDirs(t *testing.T) {
+	baseDir := t.TempDir()
+	headDir := t.TempDir()
+	files := []struct {
+		dir, name, content string
+	}{
+		{baseDir, "flows/Same.flow-meta.xml", "<Flow>same</Flow>"},
+		{headDir, "flows/Same.flow-meta.xml", "<Flow>same</Flow>"},
+		{baseDir, "flows/Changed.flow-meta.xml", "<Flow>old</Flow>"},
+		{headDir, "flows/Changed.flow-meta.xml", "<Flow>new</Flow>"},
+		{baseDir, "flows/Deleted.flow", "<Flow/>"},
+		{headDir, "nested/Added.flow-meta.xml", "<Flow/>"},
+		{headDir, "flows/notes.txt", "ignored"},
+	}
+	for _, f := range files {
+		path := filepath.Join(f.dir, filepath.FromSlash(f.name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(f.content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", f.name, err)
+		}
+	}
+
+	got, err := detectChangedFlowsInDirs(baseDir, headDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"flows/Changed.flow-meta.xml", "flows/Deleted.flow", "nested/Added.flow-meta.xml"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestLimitFlows(t *testing.T) {
+	flows := []string{"a.flow", "b.flow", "c.flow"}
+	got, omitted := limitFlows(flows, 2)
+	if strings.Join(got, ",") != "a.flow,b.flow" || omitted != 1 {
+		t.Fatalf("limitFlows(2) = %v, %d", got, omitted)
+	}
+	for _, limit := range []int{0, 3, 10} {
+		got, omitted := limitFlows(flows, limit)
+		if len(got) != len(flows) || omitted != 0 {
+			t.Fatalf("limitFlows(%d) = %v, %d", limit, got, omitted)
+		}
+	}
+}
+
+func TestNormalizeMaxFlows(t *testing.T) {
+	for value, want := range map[string]int{"": 0, "0": 0, " 25 ": 25} {
+		got, err := normalizeMaxFlows(value)
+		if err != nil || got != want {
+			t.Fatalf("normalizeMaxFlows(%q) = %d, %v", value, got, err)
+		}
+	}
+	for _, value := range []string{"-1", "many"} {
+		if _, err := normalizeMaxFlows(value); err == nil {
+			t.Fatalf("normalizeMaxFlows(%q): expected error", value)
+		}
+	}
+}
+
+func TestCoalesceFlowPairs(t *testing.T) {
+	got := coalesceFlowPairs([]string{
+		"flows/A.flow",
+		"flows/A.flow-meta.xml",
+		"flows/B.flow",
+		"flows/C.flow-meta.xml",
+		"other/A.flow",
+	})
+	want := []string{"flows/A.flow-meta.xml", "flows/B.flow", "flows/C.flow-meta.xml", "other/A.flow"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+// stubSFScript is a fake sf CLI that returns body for queries naming deployed
+// and an empty result for anything else.
+func stubSFScript(deployed, body string) string {
+	return "for arg in \"$@\"; do query=\"$arg\"; done\n" +
+		"case \"$query\" in\n" +
+		"  *\"Name = '" + deployed + "'\"*) echo '{\"status\":0,\"result\":{\"records\":[{\"Body\":\"" + body + "\"}]}}';;\n" +
+		"  *) echo '{\"status\":0,\"result\":{\"records\":[]}}';;\n" +
+		"esac\n"
+}
+
+func TestRenderFromOrg(t *testing.T) {
+	stubDir := t.TempDir()
+	writeStubScript(t, stubDir, "sf", stubSFScript("LeadHandler", "public class LeadHandler {}"))
+	prependPath(t, stubDir)
+	headDir := t.TempDir()
+	for _, name := range []string{"classes/LeadHandler.cls", "classes/LeadHandler.cls-meta.xml", "triggers/LeadTrigger.trigger"} {
+		path := filepath.Join(headDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("generated"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	baseDir := t.TempDir()
+
+	status, _, err := renderFromOrg(context.Background(), "dev", renderOK, headDir, baseDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != renderOK {
+		t.Fatalf("expected status %d, got %d", renderOK, status)
+	}
+	got, err := os.ReadFile(filepath.Join(baseDir, "classes", "LeadHandler.cls"))
+	if err != nil {
+		t.Fatalf("read org class: %v", err)
+	}
+	if string(got) != "public class LeadHandler {}" {
+		t.Fatalf("unexpected org class body %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "triggers", "LeadTrigger.trigger")); !os.IsNotExist(err) {
+		t.Fatalf("expected undeployed trigger to be absent, stat err %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(headDir, "classes", "LeadHandler.cls-meta.xml")); !os.IsNotExist(err) {
+		t.Fatalf("expected meta file to be removed from the head render, stat err %v", err)
+	}
+}
+
+func TestDiffFlow_AgainstOrgMatchingClass(t *testing.T) {
+	stubDir := t.TempDir()
+	writeStubScript(t, stubDir, "sf", stubSFScript("LeadHandler", "public class LeadHandler {}"))
+	prependPath(t, stubDir)
+	checkout := t.TempDir()
+	if err := os.WriteFile(filepath.Join(checkout, "A.flow-meta.xml"), []byte("<Flow/>"), 0o644); err != nil {
+		t.Fatalf("write flow: %v", err)
+	}
+	bin := writeStubScript(t, t.TempDir(), "flow2apex", "mkdir -p \"$3/classes\"\n"+
+		"printf 'public class LeadHandler {}' > \"$3/classes/LeadHandler.cls\"\n"+
+		"echo '<ApexClass/>' > \"$3/classes/LeadHandler.cls-meta.xml\"\n")
+	comment, err := createStreamWriter(filepath.Join(t.TempDir(), "comment.md"), 0)
+	if err != nil {
+		t.Fatalf("create comment: %v", err)
+	}
+	defer comment.Close()
+	fr := &flowRun{
+		workspace:    checkout,
+		tmpDir:       t.TempDir(),
+		headCheckout: checkout,
+		flow2apexBin: bin,
+		againstOrg:   "dev",
+		diffOpts:     diffOptions{format: diffFormatUnified},
+		fence:        commentFenceDiff,
+		comment:      comment,
+	}
+
+	result, err := fr.diffFlow(context.Background(), "A.flow-meta.xml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.baseStatus != renderOK || result.diffExit != 0 {
+		t.Fatalf("expected the deployed class to match the head render, got %+v", result)
+	}
+	var summary runSummary
+	summary.add(result)
+	if summary.ChangedFlows != 0 {
+		t.Fatalf("expected no changed flows, got %+v", summary)
+	}
+}
+
+func TestRenderFromOrg_NotDeployed(t *testing.T) {
+	stubDir := t.TempDir()
+	writeStubScript(t, stubDir, "sf", stubSFScript("Other", ""))
+	prependPath(t, stubDir)
+	headDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(headDir, "NewFlow.cls"), []byte("generated"), 0o644); err != nil {
+		t.Fatalf("write class: %v", err)
+	}
+
+	status, _, err := renderFromOrg(context.Background(), "dev", renderOK, headDir, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != renderMissing {
+		t.Fatalf("expected status %d, got %d", renderMissing, status)
+	}
+}
+
+func TestRenderFromOrg_SkipsWithoutHeadApex(t *testing.T) {
+	stubDir := t.TempDir()
+	writeStubScript(t, stubDir, "sf", stubSFScript("NewFlow", "public class NewFlow {}"))
+	prependPath(t, stubDir)
+	generatedOnly := t.TempDir()
+	if err := os.WriteFile(filepath.Join(generatedOnly, "generated.apex"), []byte("generated"), 0o644); err != nil {
+		t.Fatalf("write generated apex: %v", err)
+	}
+	withClass := t.TempDir()
+	if err := os.WriteFile(filepath.Join(withClass, "NewFlow.cls"), []byte("generated"), 0o644); err != nil {
+		t.Fatalf("write class: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		headStatus int
+		headDir    string
+		wantLog    string
+	}{
+		{name: "head failed", headStatus: renderFailed, headDir: withClass, wantLog: "head conversion failed"},
+		{name: "head timed out", headStatus: renderTimedOut, headDir: withClass, wantLog: "head conversion failed"},
+		{name: "generated.apex only", headStatus: renderOK, headDir: generatedOnly, wantLog: "no Apex class or trigger"},
+	}
+	for _, tt := range tests {
+		baseDir := t.TempDir()
+		status, log, err := renderFromOrg(context.Background(), "dev", tt.headStatus, tt.headDir, baseDir)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if status != renderSkipped || !strings.Contains(string(log), tt.wantLog) {
+			t.Fatalf("%s: expected skipped status with %q, got %d %q", tt.name, tt.wantLog, status, log)
+		}
+		if _, err := os.Stat(filepath.Join(baseDir, "NewFlow.cls")); !os.IsNotExist(err) {
+			t.Fatalf("%s: expected org apex not to be retrieved, stat err %v", tt.name, err)
+		}
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.json")
+	err := writeSummary(path, runSummary{TotalFlows: 3, ChangedFlows: 1, UnchangedFlows: 1, HeadConversionFailures: 1, Truncated: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read summary: %v", err)
+	}
+	want := `{
+  "total_flows": 3,
+  "changed_flows": 1,
+  "unchanged_flows": 1,
+  "diff_failures": 0,
+  "base_conversion_failures": 0,
+  "head_conversion_failures": 1,
+  "omitted_flows": 0,
+  "truncated": true
+}
+`
+	if string(got) != want {
+		t.Fatalf("unexpected summary:\n%s", got)
+	}
+}
+
+func TestFencedDiff_ReportsTruncation(t *testing.T) {
+	var comment strings.Builder
+	block := openFencedDiff(&comment, "diff")
+	block.writeLine("+small")
+	if block.close() {
+		t.Fatalf("expected small diff not to be truncated")
+	}
+	comment.Reset()
+	block = openFencedDiff(&comment, "diff")
+	for i := 0; i*10 <= maxDiffChars; i++ {
+		block.writeLine("+123456789")
+	}
+	if !block.close() {
+		t.Fatalf("expected large diff to be truncated")
+	}
+	if len(comment.String()) > maxDiffChars+100 || !strings.HasSuffix(comment.String(), "+123456789\n...diff truncated...\n```\n\n") {
+		t.Fatalf("expected the block to end at a whole line with a note, got %d bytes ending %q", len(comment.String()), comment.String()[len(comment.String())-40:])
+	}
+}
+
+func TestFlowDiffWriter_PerFlowFiles(t *testing.T) {
+	dir := t.TempDir()
+	out, _ := newTestFlowDiffWriter(t, true)
+	out.flowPath = "force-app/main/flows/My Flow.flow-meta.xml"
+	out.perFlowDir = dir
+	out.writeLine("-a")
+	out.writeLine("+b")
+	if _, err := out.close(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "force-app_main_flows_My_Flow.flow-meta.xml.diff"))
+	if err != nil || string(got) != "-a\n+b\n" {
+		t.Fatalf("unexpected diff file %q (err=%v)", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "force-app_main_flows_My_Flow.flow-meta.xml.html")); !os.IsNotExist(err) {
+		t.Fatalf("expected no html file for a unified diff, got err=%v", err)
+	}
+
+	out, _ = newTestFlowDiffWriter(t, false)
+	out.flowPath = "flows/A.flow"
+	out.format = diffFormatSideBySide
+	out.width = sideBySideWidth
+	out.perFlowDir = dir
+	out.perFlowHeader = "<html><body>\n"
+	out.writeLine("a <b>")
+	if _, err := out.close(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err = os.ReadFile(filepath.Join(dir, "flows_A.flow.html"))
+	want := "<html><body>\n" + sideBySideHTMLMainStart +
+		"    <h2 id=\"flow-flows_A.flow\">flows/A.flow</h2>\n" +
+		"    <pre class=\"sbs\"><span class=\"sbs-scale\">a &lt;b&gt;\n</span></pre>\n" +
+		sideBySideHTMLMainEnd + sideBySideHTMLEnd
+	if err != nil || string(got) != want {
+		t.Fatalf("unexpected html file %q (err=%v)", got, err)
+	}
+
+	out, _ = newTestFlowDiffWriter(t, true)
+	out.flowPath = "flows/B.flow"
+	out.perFlowDir = dir
+	out.writeLine("partial")
+	if _, err := out.close(false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "flows_B.flow.diff")); !os.IsNotExist(err) {
+		t.Fatalf("expected per-flow files of an unchanged flow to be removed, got err=%v", err)
+	}
+}
+
+func TestStripApexComments(t *testing.T) {
+	src := "/**\n * Generated from Flow Old_Name\n */\npublic class A {\n" +
+		"    // element: Decision_1\n" +
+		"    String url = 'https://example.com//x'; // trailing\n" +
+		"    String s = 'it\\'s /* not */ a comment';\n" +
+		"\n" +
+		"    Integer n = 1; /* inline */ Integer m = 2;\n" +
+		"}"
+	want := "public class A {\n" +
+		"    String url = 'https://example.com//x';\n" +
+		"    String s = 'it\\'s /* not */ a comment';\n" +
+		"\n" +
+		"    Integer n = 1;  Integer m = 2;\n" +
+		"}"
+	if got := stripApexComments(src); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestStripApexCommentsInDir_SuppressesCommentOnlyDiff(t *testing.T) {
+	root := t.TempDir()
+	baseDir := filepath.Join(root, "base")
+	headDir := filepath.Join(root, "head")
+	for dir, comment := range map[string]string{baseDir: "// element: Old_Name", headDir: "/* element: New_Name */"} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		body := "public class A {\n    " + comment + "\n    void run() {}\n}\n"
+		if err := os.WriteFile(filepath.Join(dir, "A.cls"), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exitCode, err := diffSideBySideNative("flows/A.flow", baseDir, headDir, sideBySideWidth, func(string) {})
+	if err != nil || exitCode != 1 {
+		t.Fatalf("expected a diff before stripping, got exit %d (err=%v)", exitCode, err)
+	}
+	for _, dir := range []string{baseDir, headDir} {
+		if err := stripApexCommentsInDir(dir); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	var lines []string
+	exitCode, err = diffSideBySideNative("flows/A.flow", baseDir, headDir, sideBySideWidth, collectLines(&lines))
+	if err != nil || exitCode != 0 {
+		t.Fatalf("expected no diff after stripping, got exit %d (err=%v):\n%s", exitCode, err, strings.Join(lines, "\n"))
+	}
+}
+
+func TestStreamWriter_Limit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "comment.md")
+	w, err := createStreamWriter(path, 12)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.WriteString("0123\n")
+	if w.truncated {
+		t.Fatalf("expected no truncation below the limit")
+	}
+	w.WriteString("567\n9ab\ndef\n")
+	w.WriteString("g\n")
+	if !w.truncated {
+		t.Fatalf("expected truncation past the limit")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected second close to be a no-op, got %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil || string(got) != "0123\n567\n" {
+		t.Fatalf("unexpected content %q (err=%v)", got, err)
+	}
+}
+
+func TestStreamWriter_LimitKeepsRunesWhole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "comment.md")
+	w, err := createStreamWriter(path, 8)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.WriteString("ok\n")
+	w.WriteString("héllo wörld\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected close error: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil || string(got) != "ok\n" {
+		t.Fatalf("unexpected content %q (err=%v)", got, err)
+	}
+}
+
+func TestCheckoutFlowPath(t *testing.T) {
+	checkout := filepath.Join(t.TempDir(), "checkout")
+	got, err := checkoutFlowPath(checkout, "force-app/main/default/flows/A.flow-meta.xml")
+	if err != nil || got != filepath.Join(checkout, "force-app", "main", "default", "flows", "A.flow-meta.xml") {
+		t.Fatalf("unexpected path %q (err=%v)", got, err)
+	}
+	for _, flowPath := range []string{"../A.flow-meta.xml", "flows/../../A.flow-meta.xml", "/etc/A.flow-meta.xml", filepath.Join(checkout, "A.flow-meta.xml"), ".."} {
+		if _, err := checkoutFlowPath(checkout, flowPath); err == nil {
+			t.Fatalf("expected %q to be rejected", flowPath)
+		}
+		if _, err := readFlowFile(checkout, flowPath); err == nil {
+			t.Fatalf("expected readFlowFile to reject %q", flowPath)
+		}
+	}
+}
+
+func TestRepoRelativeFlowPaths_SeparateCheckouts(t *testing.T) {
+	root := t.TempDir()
+	baseCheckout := filepath.Join(root, "base")
+	headCheckout := filepath.Join(root, "head")
+	for _, dir := range []string{baseCheckout, headCheckout} {
+		if err := os.MkdirAll(filepath.Join(dir, "flows"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "flows", "A.flow-meta.xml"), []byte("<Flow/>"), 0o644); err != nil {
+			t.Fatalf("write flow: %v", err)
+		}
+	}
+	abs := filepath.Join(headCheckout, "flows", "A.flow-meta.xml")
+
+	flows := repoRelativeFlowPaths([]string{abs, "flows/B.flow-meta.xml", "/elsewhere/C.flow-meta.xml"}, headCheckout, "")
+	want := []string{"flows/A.flow-meta.xml", "flows/B.flow-meta.xml", "/elsewhere/C.flow-meta.xml"}
+	if strings.Join(flows, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, flows)
+	}
+	bin := writeStubScript(t, t.TempDir(), "flow2apex", "echo 'public class A {}' > \"$3/A.cls\"\n")
+	for _, checkout := range []string{baseCheckout, headCheckout} {
+		status, log, err := renderFlow(context.Background(), checkout, bin, flows[0], t.TempDir(), 0)
+		if err != nil || status != renderOK {
+			t.Fatalf("expected %s to render in %s, got status %d log %q (err=%v)", flows[0], checkout, status, log, err)
+		}
+	}
+}
+
+func TestDiffFlow_ConversionIssueWithoutDiff(t *testing.T) {
+	checkout := t.TempDir()
+	if err := os.WriteFile(filepath.Join(checkout, "A.flow-meta.xml"), []byte("<Flow/>"), 0o644); err != nil {
+		t.Fatalf("write flow: %v", err)
+	}
+	bin := writeStubScript(t, t.TempDir(), "flow2apex", "echo 'converter failed' >&2\nexit 1\n")
+	commentPath := filepath.Join(t.TempDir(), "comment.md")
+	comment, err := createStreamWriter(commentPath, 0)
+	if err != nil {
+		t.Fatalf("create comment: %v", err)
+	}
+	defer comment.Close()
+	fr := &flowRun{
+		workspace:    checkout,
+		tmpDir:       t.TempDir(),
+		baseCheckout: checkout,
+		headCheckout: checkout,
+		flow2apexBin: bin,
+		changedOnly:  true,
+		diffOpts:     diffOptions{format: diffFormatUnified},
+		fence:        commentFenceDiff,
+		comment:      comment,
+	}
+
+	result, err := fr.diffFlow(context.Background(), "A.flow-meta.xml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.shown || result.diffExit != 0 {
+		t.Fatalf("expected the conversion issue to be shown without a diff, got %+v", result)
+	}
+	var summary runSummary
+	summary.add(result)
+	if hasFlowChanges(summary) {
+		t.Fatalf("expected a flow with only conversion issues not to count as a change, got %+v", summary)
+	}
+	comment.Close()
+	got, err := os.ReadFile(commentPath)
+	if err != nil {
+		t.Fatalf("read comment: %v", err)
+	}
+	if !strings.Contains(string(got), "- Head conversion failed") || !strings.Contains(string(got), "No generated Apex differences.") {
+		t.Fatalf("unexpected comment %q", got)
+	}
+}
 
-	got := normalizeSideBySideCommandHeaders(input)
-	if !strings.Contains(got, "diff -- a/flow/meta.xml/one.apex b/flow/meta.xml/one.apex") {
-		t.Fatalf("expected first simplified diff header")
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		summary  runSummary
+		exitCode bool
+		want     int
+	}{
+		{name: "no changes", summary: runSummary{UnchangedFlows: 1}, exitCode: true, want: exitNoChanges},
+		{name: "changed", summary: runSummary{ChangedFlows: 1, UnchangedFlows: 1}, exitCode: true, want: exitChanges},
+		{name: "diff failed", summary: runSummary{DiffFailures: 1}, exitCode: true, want: exitFailure},
+		{name: "changed and diff failed", summary: runSummary{ChangedFlows: 1, DiffFailures: 1}, exitCode: true, want: exitFailure},
+		{name: "changed without exit-code", summary: runSummary{ChangedFlows: 1}, want: exitNoChanges},
+		{name: "diff failed without exit-code", summary: runSummary{DiffFailures: 1}, want: exitNoChanges},
 	}
-	if !strings.Contains(got, "diff -- a/flow/meta.xml/two.apex b/flow/meta.xml/two.apex") {
-		t.Fatalf("expected second simplified diff header")
+	for _, tt := range tests {
+		if got := exitStatus(tt.summary, tt.exitCode); got != tt.want {
+			t.Fatalf("%s: expected exit %d, got %d", tt.name, tt.want, got)
+		}
 	}
 }
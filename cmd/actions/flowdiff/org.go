@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// apexNamePattern matches names that are safe to interpolate into a SOQL
+// string literal. Every Apex class and trigger name matches it, so generated
+// files whose names don't are skipped rather than escaped.
+var apexNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// sfQueryResponse is the subset of `sf data query --json` output used here.
+type sfQueryResponse struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Result  struct {
+		Records []struct {
+			Body string `json:"Body"`
+		} `json:"records"`
+	} `json:"result"`
+}
+
+// renderFromOrg fills baseDir with the deployed Apex from the org alias for
+// every class or trigger the converter wrote to headDir, using the same
+// relative paths. The org query returns only Apex bodies, so other files in
+// headDir, such as -meta.xml companions, are removed rather than reported as
+// added. It returns renderMissing when none of the classes or triggers are
+// deployed, so the flow is reported like a newly added one, and
+// renderSkipped, with the reason in the log, when headStatus or headDir
+// leaves nothing to look up.
+func renderFromOrg(ctx context.Context, alias string, headStatus int, headDir, baseDir string) (int, []byte, error) {
+	switch headStatus {
+	case renderOK, renderUnsupported:
+	case renderMissing:
+		return renderSkipped, []byte("org not queried: flow file missing in head\n"), nil
+	default:
+		return renderSkipped, []byte("org not queried: head conversion failed\n"), nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(headDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return renderFailed, nil, fmt.Errorf("list generated files in %s: %w", headDir, err)
+	}
+
+	var log bytes.Buffer
+	found := false
+	looked := false
+	for _, path := range files {
+		name := filepath.Base(path)
+		var sobject string
+		switch filepath.Ext(name) {
+		case ".cls":
+			sobject = "ApexClass"
+		case ".trigger":
+			sobject = "ApexTrigger"
+		default:
+			if err := os.Remove(path); err != nil {
+				return renderFailed, nil, fmt.Errorf("remove %s from head render: %w", name, err)
+			}
+			continue
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		if !apexNamePattern.MatchString(name) {
+			fmt.Fprintf(&log, "%s %q: not a valid Apex name, skipped\n", sobject, name)
+			continue
+		}
+		looked = true
+
+		body, ok, err := queryOrgApexBody(ctx, alias, sobject, name)
+		if err != nil {
+			fmt.Fprintf(&log, "%s %s: %v\n", sobject, name, err)
+			return renderFailed, log.Bytes(), nil
+		}
+		if !ok {
+			continue
+		}
+		found = true
+
+		rel, err := filepath.Rel(headDir, path)
+		if err != nil {
+			return renderFailed, nil, fmt.Errorf("relative path for %s: %w", path, err)
+		}
+		target := filepath.Join(baseDir, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return renderFailed, nil, fmt.Errorf("create org render dir: %w", err)
+		}
+		if err := os.WriteFile(target, []byte(body), 0o644); err != nil {
+			return renderFailed, nil, fmt.Errorf("write org apex %s: %w", name, err)
+		}
+	}
+	if !looked {
+		log.WriteString("org not queried: head conversion wrote no Apex class or trigger\n")
+		return renderSkipped, log.Bytes(), nil
+	}
+	if !found {
+		return renderMissing, log.Bytes(), nil
+	}
+	return renderOK, log.Bytes(), nil
+}
+
+// queryOrgApexBody looks up the unmanaged class or trigger named name with
+// the Salesforce CLI's Tooling API query. A query, unlike sf project retrieve
+// start, needs no sfdx-project.json and writes nothing into the workspace;
+// callers must check name against apexNamePattern first since it is quoted
+// into the SOQL.
+func queryOrgApexBody(ctx context.Context, alias, sobject, name string) (string, bool, error) {
+	query := fmt.Sprintf("SELECT Body FROM %s WHERE Name = '%s' AND NamespacePrefix = null", sobject, name)
+	cmd := exec.CommandContext(ctx, "sf", "data", "query", "--use-tooling-api", "--json", "--target-org", alias, "--query", query)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+	if runErr != nil {
+		if _, ok := runErr.(*exec.ExitError); !ok {
+			return "", false, fmt.Errorf("run sf: %w", runErr)
+		}
+	}
+
+	var resp sfQueryResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", false, fmt.Errorf("sf data query: %s", msg)
+		}
+		return "", false, fmt.Errorf("parse sf data query output: %w", err)
+	}
+	if runErr != nil || resp.Status != 0 {
+		return "", false, fmt.Errorf("sf data query: %s", resp.Message)
+	}
+	if len(resp.Result.Records) == 0 {
+		return "", false, nil
+	}
+	return resp.Result.Records[0].Body, true, nil
+}
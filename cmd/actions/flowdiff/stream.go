@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// diffLineFunc receives each line of diff output, without its trailing
+// newline, as soon as diff writes it.
+type diffLineFunc func(line string)
+
+// runDiffCommand runs cmd and passes each line of its stdout to emit while it
+// runs, so no diff is held in memory whole. It returns diff's exit code and
+// stderr.
+func runDiffCommand(cmd *exec.Cmd, emit diffLineFunc) (int, string, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 2, "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return 2, "", err
+	}
+	readErr := readLines(stdout, emit)
+	if readErr != nil {
+		// Drain the pipe so diff can exit before Wait.
+		io.Copy(io.Discard, stdout)
+	}
+	err = cmd.Wait()
+	if readErr != nil {
+		return 2, "", readErr
+	}
+	if err == nil {
+		return 0, stderr.String(), nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), stderr.String(), nil
+	}
+	return 2, "", err
+}
+
+// readLines passes each line of r to emit. A final line without a newline is
+// passed on as well.
+func readLines(r io.Reader, emit diffLineFunc) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			emit(strings.TrimSuffix(line, "\n"))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// emitLines passes each line of text to emit.
+func emitLines(text string, emit diffLineFunc) {
+	for text != "" {
+		line, rest, _ := strings.Cut(text, "\n")
+		emit(line)
+		text = rest
+	}
+}
+
+// sideBySideLineRewriter wraps emit so each side-by-side line has the render
+// directories replaced with a/<flow path> and b/<flow path>, and each
+// recursive diff command header reduced to "diff -- <left> <right>" after a
+// blank line.
+func sideBySideLineRewriter(flowPath, baseDir, headDir string, emit diffLineFunc) diffLineFunc {
+	replacer := strings.NewReplacer(
+		baseDir, "a/"+flowPath,
+		headDir, "b/"+flowPath,
+	)
+	started := false
+	lastBlank := false
+	return func(line string) {
+		line = replacer.Replace(line)
+		if isSideBySideCommandHeader(line) {
+			header := simplifySideBySideCommandHeader(line)
+			if header == "" {
+				return
+			}
+			if started && !lastBlank {
+				emit("")
+			}
+			line = header
+		}
+		emit(line)
+		started = true
+		lastBlank = line == ""
+	}
+}
+
+// renderedOutputsDiffer reports whether baseDir and headDir hold different
+// sets of files or any file whose contents differ. It reads files in chunks
+// so large renders are never loaded whole.
+func renderedOutputsDiffer(baseDir, headDir string) (bool, error) {
+	baseFiles, err := listRenderedFiles(baseDir)
+	if err != nil {
+		return false, err
+	}
+	headFiles, err := listRenderedFiles(headDir)
+	if err != nil {
+		return false, err
+	}
+	if len(baseFiles) != len(headFiles) {
+		return true, nil
+	}
+	for i, rel := range baseFiles {
+		if headFiles[i] != rel {
+			return true, nil
+		}
+		same, err := sameFileContents(filepath.Join(baseDir, rel), filepath.Join(headDir, rel))
+		if err != nil {
+			return false, err
+		}
+		if !same {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// listRenderedFiles returns the regular files below dir, relative to it, in
+// lexical order.
+func listRenderedFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list rendered files in %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+func sameFileContents(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		doneA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		doneB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !doneA {
+			return false, errA
+		}
+		if errB != nil && !doneB {
+			return false, errB
+		}
+		if doneA || doneB {
+			return doneA == doneB, nil
+		}
+	}
+}
+
+// fencedDiff writes one fenced code block to the comment a line at a time.
+// Lines that would take the block past maxDiffChars are dropped and a
+// truncation note is written when the block is closed.
+type fencedDiff struct {
+	w         io.StringWriter
+	size      int
+	truncated bool
+}
+
+func openFencedDiff(w io.StringWriter, fence string) *fencedDiff {
+	w.WriteString("```" + fence + "\n")
+	return &fencedDiff{w: w}
+}
+
+func (f *fencedDiff) writeLine(line string) {
+	if f.truncated {
+		return
+	}
+	if f.size+len(line)+1 > maxDiffChars {
+		f.truncated = true
+		return
+	}
+	f.size += len(line) + 1
+	f.w.WriteString(line)
+	f.w.WriteString("\n")
+}
+
+// close ends the block and reports whether lines were dropped.
+func (f *fencedDiff) close() bool {
+	if f.truncated {
+		f.w.WriteString("...diff truncated...\n")
+	}
+	f.w.WriteString("```\n\n")
+	return f.truncated
+}
+
+// flowDiffWriter copies one flow's diff output, line by line as diff produces
+// it, into the comment, the side-by-side html report and, with a per-flow
+// directory, the flow's own .diff and .html files.
+type flowDiffWriter struct {
+	flowPath  string
+	baseDir   string
+	headDir   string
+	format    string
+	fence     string
+	flat      bool
+	width     int
+	highlight bool
+
+	comment *streamWriter
+	// html is the side-by-side report; it is nil for unified diffs.
+	html *streamWriter
+
+	// perFlowDir, when set, receives <flow>.diff and, for side-by-side,
+	// <flow>.html opened with perFlowHeader.
+	perFlowDir    string
+	perFlowHeader string
+	perFlowDiff   *streamWriter
+	perFlowHTML   *streamWriter
+
+	block     *fencedDiff
+	htmlOpen  bool
+	truncated bool
+	err       error
+}
+
+func (w *flowDiffWriter) writeLine(line string) {
+	if w.perFlowDir != "" && w.perFlowDiff == nil && w.err == nil {
+		w.openPerFlow()
+	}
+	if w.perFlowDiff != nil {
+		w.perFlowDiff.WriteString(line)
+		w.perFlowDiff.WriteString("\n")
+	}
+
+	if w.format == diffFormatSideBySide {
+		if !w.htmlOpen {
+			w.htmlOpen = true
+			w.writeHTML(func(out io.StringWriter) {
+				writeSideBySideHTMLHeading(out, w.flowPath)
+				out.WriteString("    <pre class=\"sbs\"><span class=\"sbs-scale\">")
+			})
+		}
+		formatted := formatSideBySideDiffHTMLLine(line, w.width, w.highlight)
+		w.writeHTML(func(out io.StringWriter) {
+			out.WriteString(formatted)
+			out.WriteString("\n")
+		})
+		// The comment keeps only file headers and changed lines; the html
+		// report carries the full side-by-side context.
+		if w.block == nil {
+			w.block = openFencedDiff(w.comment, commentFenceText)
+		}
+		if keepSideBySideCommentLine(line, w.width) {
+			w.block.writeLine(line)
+		}
+		return
+	}
+
+	if !w.flat && strings.HasPrefix(line, "diff --git ") {
+		w.closeBlock()
+		w.comment.WriteString(fmt.Sprintf("#### `%s`\n\n", unifiedDiffFileName(line, w.flowPath, w.baseDir, w.headDir)))
+		w.block = openFencedDiff(w.comment, w.fence)
+	}
+	if w.block == nil {
+		if !w.flat {
+			w.comment.WriteString(fmt.Sprintf("#### `%s`\n\n", w.flowPath))
+		}
+		w.block = openFencedDiff(w.comment, w.fence)
+	}
+	w.block.writeLine(line)
+}
+
+// writeHTML runs write against the report and the per-flow html file.
+func (w *flowDiffWriter) writeHTML(write func(io.StringWriter)) {
+	if w.html != nil {
+		write(w.html)
+	}
+	if w.perFlowHTML != nil {
+		write(w.perFlowHTML)
+	}
+}
+
+func (w *flowDiffWriter) openPerFlow() {
+	name := filepath.Join(w.perFlowDir, sanitizeFlowPath(w.flowPath))
+	diffFile, err := createStreamWriter(name+".diff", 0)
+	if err != nil {
+		w.err = fmt.Errorf("write per-flow diff for %s: %w", w.flowPath, err)
+		return
+	}
+	w.perFlowDiff = diffFile
+	if w.format != diffFormatSideBySide {
+		return
+	}
+	htmlFile, err := createStreamWriter(name+".html", 0)
+	if err != nil {
+		w.err = fmt.Errorf("write per-flow html for %s: %w", w.flowPath, err)
+		return
+	}
+	htmlFile.WriteString(w.perFlowHeader)
+	htmlFile.WriteString(sideBySideHTMLMainStart)
+	w.perFlowHTML = htmlFile
+}
+
+func (w *flowDiffWriter) closeBlock() {
+	if w.block != nil {
+		w.truncated = w.block.close() || w.truncated
+		w.block = nil
+	}
+}
+
+// close ends any open comment block and html section and finishes the
+// per-flow files, removing them unless the flow changed. It reports whether
+// a comment block was truncated.
+func (w *flowDiffWriter) close(changed bool) (bool, error) {
+	w.closeBlock()
+	if w.htmlOpen {
+		w.writeHTML(func(out io.StringWriter) {
+			out.WriteString("</span></pre>\n")
+		})
+	}
+	if w.perFlowHTML != nil {
+		w.perFlowHTML.WriteString(sideBySideHTMLMainEnd + sideBySideHTMLEnd)
+	}
+	for _, f := range []*streamWriter{w.perFlowDiff, w.perFlowHTML} {
+		if f == nil {
+			continue
+		}
+		if err := f.Close(); err != nil && w.err == nil {
+			w.err = fmt.Errorf("write per-flow output for %s: %w", w.flowPath, err)
+		}
+		if !changed {
+			os.Remove(f.path)
+		}
+	}
+	return w.truncated, w.err
+}
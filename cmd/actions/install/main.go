@@ -1,16 +1,39 @@
 package main
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/octoberswimmer/flow2apex/actions/internal/release"
+)
+
+const (
+	defaultMaxRetries      = 2
+	retryBaseDelay         = time.Second
+	defaultDownloadBaseURL = "https://github.com"
+	// maxRetryAfter is the longest Retry-After the installer will wait out;
+	// a server asking for more is treated as a failure.
+	maxRetryAfter = 60 * time.Second
 )
 
 func main() {
@@ -19,18 +42,46 @@ func main() {
 	var runnerOS string
 	var runnerArch string
 	var dest string
+	var checksum string
+	var checksumsURL string
+	var archiveExt string
+	var maxRetries int
+	var includePrereleases bool
+	var force bool
+	var skipVerify bool
+	var binaryName string
+	var baseURL string
 
 	flag.StringVar(&repo, "repo", "", "repository that hosts release assets")
-	flag.StringVar(&version, "version", "", "release tag to download")
+	flag.StringVar(&version, "version", "", "release tag to download (use 'latest' to resolve dynamically)")
 	flag.StringVar(&runnerOS, "runner-os", "", "runner operating system")
 	flag.StringVar(&runnerArch, "runner-arch", "", "runner architecture")
 	flag.StringVar(&dest, "dest", "", "destination directory for the flow2apex binary")
+	flag.StringVar(&checksum, "checksum", os.Getenv("FLOW2APEX_CHECKSUM"), "expected SHA-256 digest of the release archive")
+	flag.StringVar(&archiveExt, "archive-ext", os.Getenv("FLOW2APEX_ARCHIVE_EXT"), "release archive extension: zip or tar.gz (default zip)")
+	flag.StringVar(&checksumsURL, "checksums-url", os.Getenv("FLOW2APEX_CHECKSUMS_URL"), "URL of a checksums file listing the SHA-256 digest of the release archive")
+	flag.BoolVar(&includePrereleases, "include-prereleases", envBool("FLOW2APEX_INCLUDE_PRERELEASES"), "consider prereleases when resolving 'latest'")
+	flag.IntVar(&maxRetries, "max-retries", envInt("FLOW2APEX_MAX_RETRIES", defaultMaxRetries), "number of times to retry a failed download")
+	flag.StringVar(&baseURL, "base-url", os.Getenv("FLOW2APEX_DOWNLOAD_BASE_URL"), "base URL that serves <repo>/releases/download/<version>/<asset> (default https://github.com)")
+	flag.BoolVar(&force, "force", envBool("FLOW2APEX_FORCE"), "download even when the destination already holds the requested version")
+	flag.StringVar(&binaryName, "binary-name", os.Getenv("FLOW2APEX_BINARY_NAME"), "filename for the installed binary, for example flow2apex-1.2.0 (default flow2apex)")
+	flag.BoolVar(&skipVerify, "skip-verify", envBool("FLOW2APEX_SKIP_VERIFY"), "skip running the installed binary with --version before publishing it")
 	flag.Parse()
 
 	if repo == "" || version == "" {
 		log.Fatal("both --repo and --version are required")
 	}
 
+	client := newHTTPClient()
+	if strings.TrimSpace(version) == "latest" {
+		resolved, err := resolveLatestVersion(client, repo, includePrereleases)
+		if err != nil {
+			log.Fatal(err)
+		}
+		version = resolved
+		fmt.Printf("Resolved latest flow2apex release %s\n", version)
+	}
+
 	runnerOS = strings.TrimSpace(runnerOS)
 	runnerArch = strings.TrimSpace(runnerArch)
 	if runnerOS == "" {
@@ -44,7 +95,7 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	arch, err := normalizeArch(platform, runnerArch)
+	arch, err := normalizeArch(runnerArch)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -56,56 +107,159 @@ func main() {
 		log.Fatalf("create dest directory: %v", err)
 	}
 
-	archiveName := fmt.Sprintf("flow2apex_%s_%s_%s.zip", platform, arch, version)
-	url := fmt.Sprintf("https://github.com/%s/releases/download/%s/%s", repo, version, archiveName)
+	ext, err := normalizeArchiveExt(archiveExt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	archiveName := fmt.Sprintf("flow2apex_%s_%s_%s.%s", platform, arch, version, ext)
+	url := releaseAssetURL(baseURL, repo, version, archiveName)
+
+	binaryName, err = normalizeBinaryName(binaryName, platform)
+	if err != nil {
+		log.Fatal(err)
+	}
+	finalPath := filepath.Join(dest, binaryName)
+
+	if !force && cachedBinaryMatches(finalPath, version) {
+		fmt.Printf("Found cached flow2apex %s at %s; skipping download\n", version, finalPath)
+	} else {
+		dl := newDownloader(client, maxRetries)
+		if err := downloadAndInstall(dl, url, archiveName, checksum, checksumsURL, platform, finalPath); err != nil {
+			log.Fatal(explainDownloadError(err, platform, arch))
+		}
+		if !skipVerify {
+			if err := verifyBinary(finalPath, platform, arch); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	pathFile := os.Getenv("GITHUB_PATH")
+	if pathFile == "" {
+		log.Fatal("GITHUB_PATH is not set")
+	}
+	if err := appendLine(pathFile, dest); err != nil {
+		log.Fatalf("update GITHUB_PATH: %v", err)
+	}
+
+	outputFile := os.Getenv("GITHUB_OUTPUT")
+	if outputFile == "" {
+		log.Fatal("GITHUB_OUTPUT is not set")
+	}
+	outputs := []string{
+		fmt.Sprintf("binary=%s", finalPath),
+		fmt.Sprintf("version=%s", version),
+		fmt.Sprintf("platform=%s_%s", platform, arch),
+	}
+	for _, line := range outputs {
+		if err := appendLine(outputFile, line); err != nil {
+			log.Fatalf("write GITHUB_OUTPUT: %v", err)
+		}
+	}
+
+	fmt.Printf("Installed flow2apex binary to %s\n", finalPath)
+}
 
+func downloadAndInstall(dl *downloader, url, archiveName, checksum, checksumsURL, platform, finalPath string) error {
 	tmpDir, err := os.MkdirTemp("", "flow2apex-action-install-*")
 	if err != nil {
-		log.Fatalf("create temp directory: %v", err)
+		return fmt.Errorf("create temp directory: %w", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
 	archivePath := filepath.Join(tmpDir, archiveName)
-	if err := downloadFile(url, archivePath); err != nil {
-		log.Fatalf("download archive: %v", err)
+	if err := dl.downloadFile(url, archivePath); err != nil {
+		return fmt.Errorf("download archive: %w", err)
+	}
+	if err := verifyArchive(dl, archivePath, archiveName, checksum, checksumsURL); err != nil {
+		return fmt.Errorf("verify archive: %w", err)
 	}
 
 	extracted, err := extractFlow2ApexBinary(archivePath, tmpDir)
 	if err != nil {
-		log.Fatalf("extract flow2apex binary: %v", err)
+		return fmt.Errorf("extract flow2apex binary: %w", err)
 	}
 
-	binaryName := "flow2apex"
-	if platform == "windows" {
-		binaryName = "flow2apex.exe"
-	}
-	finalPath := filepath.Join(dest, binaryName)
 	if err := moveFile(extracted, finalPath); err != nil {
-		log.Fatalf("move binary: %v", err)
+		return fmt.Errorf("move binary: %w", err)
 	}
 	if platform != "windows" {
 		if err := os.Chmod(finalPath, 0o755); err != nil {
-			log.Fatalf("chmod binary: %v", err)
+			return fmt.Errorf("chmod binary: %w", err)
 		}
 	}
+	return nil
+}
 
-	pathFile := os.Getenv("GITHUB_PATH")
-	if pathFile == "" {
-		log.Fatal("GITHUB_PATH is not set")
+// cachedBinaryMatches reports whether path already holds a flow2apex binary
+// whose --version output names the requested version.
+func cachedBinaryMatches(path, version string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return false
 	}
-	if err := appendLine(pathFile, dest); err != nil {
-		log.Fatalf("update GITHUB_PATH: %v", err)
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return false
 	}
+	return versionOutputMatches(string(out), version)
+}
 
-	outputFile := os.Getenv("GITHUB_OUTPUT")
-	if outputFile == "" {
-		log.Fatal("GITHUB_OUTPUT is not set")
+// verifyBinary runs the installed binary with --version so a build for the
+// wrong platform fails here rather than in a later step.
+func verifyBinary(path, platform, arch string) error {
+	out, err := exec.Command(path, "--version").CombinedOutput()
+	if err == nil {
+		return nil
 	}
-	if err := appendLine(outputFile, fmt.Sprintf("binary=%s", finalPath)); err != nil {
-		log.Fatalf("write GITHUB_OUTPUT: %v", err)
+	msg := strings.TrimSpace(string(out))
+	if msg == "" {
+		msg = err.Error()
 	}
+	return fmt.Errorf("installed flow2apex binary %s does not run on this runner (expected a %s_%s build): %s; rerun with --skip-verify to bypass this check", path, platform, arch, msg)
+}
 
-	fmt.Printf("Installed flow2apex binary to %s\n", finalPath)
+// versionOutputMatches compares the last word of output such as
+// "flow2apex version v0.2.0" against version, ignoring a leading "v".
+func versionOutputMatches(output, version string) bool {
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return false
+	}
+	installed := strings.TrimPrefix(fields[len(fields)-1], "v")
+	requested := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	return requested != "" && installed == requested
+}
+
+// newHTTPClient returns a client whose transport honors HTTP_PROXY, HTTPS_PROXY,
+// and NO_PROXY.
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	return &http.Client{Transport: transport}
+}
+
+// releaseAssetURL builds <base>/<repo>/releases/download/<version>/<asset>,
+// defaulting base to https://github.com.
+func releaseAssetURL(baseURL, repo, version, asset string) string {
+	baseURL = strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultDownloadBaseURL
+	}
+	return fmt.Sprintf("%s/%s/releases/download/%s/%s", baseURL, repo, version, asset)
+}
+
+func resolveLatestVersion(client *http.Client, repo string, includePrereleases bool) (string, error) {
+	tag, err := release.LatestTag(client, repo, includePrereleases)
+	if err == nil {
+		return tag, nil
+	}
+	hint := "pin an explicit --version (for example v0.2.0) to skip the GitHub API lookup"
+	var statusErr *release.StatusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusForbidden || statusErr.StatusCode == http.StatusTooManyRequests) {
+		hint = "the GitHub API rate limit may be exhausted; set GITHUB_TOKEN or " + hint
+	}
+	return "", fmt.Errorf("resolve latest release of %s: %v; %s", repo, err, hint)
 }
 
 func normalizeOS(osName string) (string, error) {
@@ -121,57 +275,445 @@ func normalizeOS(osName string) (string, error) {
 	}
 }
 
-func normalizeArch(platform, arch string) (string, error) {
+// normalizeBinaryName defaults the installed filename to flow2apex and adds
+// the .exe suffix Windows needs to run it.
+func normalizeBinaryName(name, platform string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "flow2apex"
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid --binary-name %q: must be a file name without directories", name)
+	}
+	if platform == "windows" && !strings.EqualFold(filepath.Ext(name), ".exe") {
+		name += ".exe"
+	}
+	return name, nil
+}
+
+// missingAssetHint explains how to proceed when no release asset exists for a
+// platform whose builds are not published for every release.
+func missingAssetHint(platform, arch string) string {
+	if platform == "windows" && arch == "arm64" {
+		return "windows arm64 builds are not published for this release; rerun with --runner-arch amd64 to use the amd64 build under emulation"
+	}
+	return ""
+}
+
+// explainDownloadError adds a platform-specific hint when the release asset
+// was not found.
+func explainDownloadError(err error, platform, arch string) error {
+	var statusErr *httpStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		return err
+	}
+	if hint := missingAssetHint(platform, arch); hint != "" {
+		return fmt.Errorf("%w; %s", err, hint)
+	}
+	return err
+}
+
+func normalizeArch(arch string) (string, error) {
 	switch strings.ToLower(arch) {
 	case "amd64", "x86_64", "x64":
 		return "amd64", nil
 	case "arm64", "aarch64":
-		if platform == "windows" {
-			return "", fmt.Errorf("windows arm64 is not supported by published flow2apex builds")
-		}
 		return "arm64", nil
 	default:
 		return "", fmt.Errorf("unsupported architecture: %q", arch)
 	}
 }
 
-func downloadFile(url, dest string) error {
-	resp, err := http.Get(url)
+// downloader fetches release assets, retrying connection errors and 5xx/429
+// responses with exponential backoff.
+type downloader struct {
+	client     *http.Client
+	maxRetries int
+	baseDelay  time.Duration
+	sleep      func(time.Duration)
+	now        func() time.Time
+}
+
+func newDownloader(client *http.Client, maxRetries int) *downloader {
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	return &downloader{
+		client:     client,
+		maxRetries: maxRetries,
+		baseDelay:  retryBaseDelay,
+		sleep:      time.Sleep,
+		now:        time.Now,
+	}
+}
+
+// httpStatusError reports an unsuccessful HTTP response.
+type httpStatusError struct {
+	URL        string
+	Status     string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %s from %s", e.Status, e.URL)
+}
+
+// downloadFile fetches url into dest. When the server advertises byte-range
+// support, a retry after a dropped connection resumes from the bytes already
+// written instead of starting over.
+func (d *downloader) downloadFile(url, dest string) error {
+	var offset int64
+	for attempt := 0; ; attempt++ {
+		resumable, err := d.downloadOnce(url, dest, offset)
+		if err == nil {
+			return nil
+		}
+		if attempt >= d.maxRetries || !retryable(err) {
+			return err
+		}
+
+		offset = 0
+		if resumable {
+			if info, statErr := os.Stat(dest); statErr == nil {
+				offset = info.Size()
+			}
+		}
+
+		delay := d.baseDelay << attempt
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			if statusErr.RetryAfter > maxRetryAfter {
+				return fmt.Errorf("%w; server asked to retry after %s, longer than the %s limit", err, statusErr.RetryAfter, maxRetryAfter)
+			}
+			delay = statusErr.RetryAfter
+		}
+		if offset > 0 {
+			log.Printf("download attempt %d of %d failed: %v; resuming at byte %d in %s", attempt+1, d.maxRetries+1, err, offset, delay)
+		} else {
+			log.Printf("download attempt %d of %d failed: %v; retrying in %s", attempt+1, d.maxRetries+1, err, delay)
+		}
+		d.sleep(delay)
+	}
+}
+
+// downloadOnce requests url, asking for the bytes from offset onward when
+// offset is positive. A 206 response is appended to dest; any other success
+// rewrites dest from the start. The returned bool reports whether the server
+// accepts range requests, so a failed transfer can be resumed.
+func (d *downloader) downloadOnce(url, dest string, offset int64) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return offset > 0, err
 	}
 	defer resp.Body.Close()
 
+	if offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		return d.downloadOnce(url, dest, 0)
+	}
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("unexpected HTTP status %s from %s", resp.Status, url)
+		return false, &httpStatusError{
+			URL:        url,
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), d.now()),
+		}
 	}
 
-	out, err := os.Create(dest)
+	resumable := strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes")
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			log.Printf("unexpected Content-Range %q resuming %s at byte %d; downloading from the start", resp.Header.Get("Content-Range"), url, offset)
+			resp.Body.Close()
+			return d.downloadOnce(url, dest, 0)
+		}
+		resumable = true
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+
+	out, err := os.OpenFile(dest, flags, 0o644)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer out.Close()
 
 	_, err = io.Copy(out, resp.Body)
-	return err
+	return resumable, err
+}
+
+// contentRangeStart parses the first byte position from a Content-Range
+// header such as "bytes 100-199/200".
+func contentRangeStart(value string) (int64, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(value), "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if err != nil || start < 0 {
+		return 0, false
+	}
+	return start, true
+}
+
+// retryable reports whether a download error is worth retrying: 5xx and 429
+// responses, timeouts, dropped or refused connections, and truncated bodies.
+// Everything else, including malformed URLs, TLS certificate failures, and
+// local file errors, fails immediately.
+func retryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// parseRetryAfter accepts both delay-seconds and HTTP-date forms.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := at.Sub(now); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+func verifyArchive(dl *downloader, archivePath, archiveName, checksum, checksumsURL string) error {
+	expected := normalizeChecksum(checksum)
+	if expected == "" && strings.TrimSpace(checksumsURL) != "" {
+		resolved, err := fetchChecksum(dl, strings.TrimSpace(checksumsURL), archiveName, archivePath+".checksums")
+		if err != nil {
+			return err
+		}
+		expected = resolved
+	}
+	if expected == "" {
+		log.Printf("warning: no --checksum or --checksums-url provided; skipping integrity check for %s", archiveName)
+		return nil
+	}
+
+	actual, err := fileSHA256(archivePath)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", archiveName, expected, actual)
+	}
+	fmt.Printf("Verified SHA-256 checksum of %s\n", archiveName)
+	return nil
+}
+
+func normalizeChecksum(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	return strings.TrimPrefix(value, "sha256:")
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fetchChecksum downloads the checksums file to dest, with the same retries
+// as the archive, and looks up archiveName in it.
+func fetchChecksum(dl *downloader, url, archiveName, dest string) (string, error) {
+	if err := dl.downloadFile(url, dest); err != nil {
+		return "", fmt.Errorf("download checksums: %w", err)
+	}
+	f, err := os.Open(dest)
+	if err != nil {
+		return "", fmt.Errorf("read checksums: %w", err)
+	}
+	defer f.Close()
+	return parseChecksums(f, archiveName)
+}
+
+// parseChecksums finds archiveName in sha256sum/shasum formatted output, where
+// each line is "<digest>  <name>" or "<digest> *<name>".
+func parseChecksums(r io.Reader, archiveName string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		name := strings.TrimPrefix(fields[1], "*")
+		if filepath.Base(name) == archiveName {
+			return normalizeChecksum(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("read checksums: %w", err)
+	}
+	return "", fmt.Errorf("checksum for %s not found in checksums file", archiveName)
+}
+
+const (
+	archiveZip   = "zip"
+	archiveTarGz = "tar.gz"
+)
+
+func normalizeArchiveExt(ext string) (string, error) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")) {
+	case "", "zip":
+		return archiveZip, nil
+	case "tar.gz", "tgz":
+		return archiveTarGz, nil
+	default:
+		return "", fmt.Errorf("unsupported archive extension: %q (expected zip or tar.gz)", ext)
+	}
+}
+
+// detectArchiveType uses the file extension when it is recognized and falls
+// back to the zip or gzip magic bytes otherwise.
+func detectArchiveType(archivePath string) (string, error) {
+	lower := strings.ToLower(archivePath)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return archiveZip, nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return archiveTarGz, nil
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return archiveZip, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return archiveTarGz, nil
+	default:
+		return "", fmt.Errorf("unrecognized archive format: %s", filepath.Base(archivePath))
+	}
 }
 
 func extractFlow2ApexBinary(archivePath, destDir string) (string, error) {
+	archiveType, err := detectArchiveType(archivePath)
+	if err != nil {
+		return "", err
+	}
+	if archiveType == archiveTarGz {
+		return extractFlow2ApexFromTarGz(archivePath, destDir)
+	}
+	return extractFlow2ApexFromZip(archivePath, destDir)
+}
+
+// isFlow2ApexEntry reports whether an archive entry may hold the flow2apex
+// binary.
+func isFlow2ApexEntry(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), "flow2apex")
+}
+
+// selectFlow2ApexEntry picks the archive entry holding the binary. An entry
+// named exactly flow2apex or flow2apex.exe wins; otherwise a single entry
+// starting with flow2apex is used. More than one exact match, or no exact
+// match and more than one prefix match, is an error rather than a guess.
+func selectFlow2ApexEntry(names []string) (string, error) {
+	var exact, prefixed []string
+	for _, name := range names {
+		switch base := filepath.Base(name); {
+		case base == "flow2apex" || base == "flow2apex.exe":
+			exact = append(exact, name)
+		case isFlow2ApexEntry(name):
+			prefixed = append(prefixed, name)
+		}
+	}
+	switch {
+	case len(exact) == 1:
+		return exact[0], nil
+	case len(exact) > 1:
+		return "", fmt.Errorf("ambiguous flow2apex binary in archive: %d entries are named flow2apex: %s", len(exact), quoteEntries(exact))
+	case len(prefixed) == 1:
+		return prefixed[0], nil
+	case len(prefixed) > 1:
+		return "", fmt.Errorf("ambiguous flow2apex binary in archive: no entry is named flow2apex and %d entries start with it: %s", len(prefixed), quoteEntries(prefixed))
+	default:
+		return "", fmt.Errorf("flow2apex binary not found in archive")
+	}
+}
+
+func quoteEntries(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = strconv.Quote(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func extractFlow2ApexFromZip(archivePath, destDir string) (string, error) {
 	zr, err := zip.OpenReader(archivePath)
 	if err != nil {
 		return "", err
 	}
 	defer zr.Close()
 
+	var names []string
 	for _, f := range zr.File {
-		if f.FileInfo().IsDir() {
-			continue
+		if !f.FileInfo().IsDir() {
+			names = append(names, f.Name)
 		}
-		base := filepath.Base(f.Name)
-		if !strings.HasPrefix(base, "flow2apex") {
+	}
+	name, err := selectFlow2ApexEntry(names)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range zr.File {
+		if f.Name != name || f.FileInfo().IsDir() {
 			continue
 		}
-		target := filepath.Join(destDir, base)
+		target := filepath.Join(destDir, filepath.Base(f.Name))
 		if err := extractZipFile(f, target); err != nil {
 			return "", err
 		}
@@ -180,26 +722,98 @@ func extractFlow2ApexBinary(archivePath, destDir string) (string, error) {
 	return "", fmt.Errorf("flow2apex binary not found in archive")
 }
 
-func extractZipFile(file *zip.File, dest string) error {
-	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+// extractFlow2ApexFromTarGz reads the archive twice: once to choose the
+// entry and once to extract it, since tar entries can only be read in order.
+func extractFlow2ApexFromTarGz(archivePath, destDir string) (string, error) {
+	var names []string
+	err := walkTarGz(archivePath, func(header *tar.Header, _ io.Reader) (bool, error) {
+		names = append(names, header.Name)
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	name, err := selectFlow2ApexEntry(names)
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(destDir, filepath.Base(name))
+	found := false
+	err = walkTarGz(archivePath, func(header *tar.Header, r io.Reader) (bool, error) {
+		if header.Name != name {
+			return false, nil
+		}
+		found = true
+		return true, writeExtractedFile(r, target, header.FileInfo().Mode())
+	})
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("flow2apex binary not found in archive")
+	}
+	return target, nil
+}
+
+// walkTarGz calls fn for each regular file in a gzipped tar archive until fn
+// returns true or an error.
+func walkTarGz(archivePath string, fn func(*tar.Header, io.Reader) (bool, error)) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
 		return err
 	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		done, err := fn(header, tr)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+func extractZipFile(file *zip.File, dest string) error {
 	rc, err := file.Open()
 	if err != nil {
 		return err
 	}
 	defer rc.Close()
 
+	return writeExtractedFile(rc, dest, file.Mode())
+}
+
+func writeExtractedFile(r io.Reader, dest string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
 	out, err := os.Create(dest)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
-	if _, err := io.Copy(out, rc); err != nil {
+	if _, err := io.Copy(out, r); err != nil {
 		return err
 	}
-	if mode := file.Mode(); mode != 0 {
+	if mode != 0 {
 		if err := out.Chmod(mode); err != nil {
 			return err
 		}
@@ -240,6 +854,25 @@ func copyFile(src, dest string) error {
 	return err
 }
 
+func envBool(name string) bool {
+	value, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(name)))
+	return err == nil && value
+}
+
+// envInt returns the integer in the named environment variable, or def when
+// it is unset or empty.
+func envInt(name string, def int) int {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("invalid %s %q: must be an integer", name, value)
+	}
+	return n
+}
+
 func appendLine(path, line string) error {
 	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
 	if err != nil {
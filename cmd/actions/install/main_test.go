@@ -0,0 +1,493 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestDownloader(maxRetries int) (*downloader, *[]time.Duration) {
+	var sleeps []time.Duration
+	d := newDownloader(http.DefaultClient, maxRetries)
+	d.sleep = func(delay time.Duration) { sleeps = append(sleeps, delay) }
+	return d, &sleeps
+}
+
+func TestDownloadFile_RetriesServerErrors(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("archive"))
+	}))
+	defer srv.Close()
+
+	d, sleeps := newTestDownloader(2)
+	dest := filepath.Join(t.TempDir(), "archive.zip")
+	if err := d.downloadFile(srv.URL, dest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+	if len(*sleeps) != 2 || (*sleeps)[0] != retryBaseDelay || (*sleeps)[1] != 2*retryBaseDelay {
+		t.Fatalf("expected exponential backoff, got %v", *sleeps)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil || string(data) != "archive" {
+		t.Fatalf("unexpected downloaded content %q (err=%v)", data, err)
+	}
+}
+
+func TestDownloadFile_DoesNotRetryNotFound(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	d, sleeps := newTestDownloader(2)
+	err := d.downloadFile(srv.URL, filepath.Join(t.TempDir(), "archive.zip"))
+	if err == nil {
+		t.Fatalf("expected error for 404")
+	}
+	if calls != 1 || len(*sleeps) != 0 {
+		t.Fatalf("expected a single attempt without sleeping, got %d calls and sleeps %v", calls, *sleeps)
+	}
+}
+
+func TestDownloadFile_RespectsRetryAfter(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("archive"))
+	}))
+	defer srv.Close()
+
+	d, sleeps := newTestDownloader(2)
+	if err := d.downloadFile(srv.URL, filepath.Join(t.TempDir(), "archive.zip")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*sleeps) != 1 || (*sleeps)[0] != 7*time.Second {
+		t.Fatalf("expected Retry-After delay, got %v", *sleeps)
+	}
+}
+
+func TestDownloadFile_GivesUpAfterMaxRetries(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	d, _ := newTestDownloader(1)
+	if err := d.downloadFile(srv.URL, filepath.Join(t.TempDir(), "archive.zip")); err == nil {
+		t.Fatalf("expected error after exhausting retries")
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 attempts, got %d", calls)
+	}
+}
+
+func TestDownloadFile_ResumesWithRange(t *testing.T) {
+	const payload = "0123456789abcdefghij"
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("hijack: %v", err)
+				return
+			}
+			fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nAccept-Ranges: bytes\r\nContent-Length: %d\r\n\r\n%s", len(payload), payload[:8])
+			buf.Flush()
+			conn.Close()
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 8-%d/%d", len(payload)-1, len(payload)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(payload[8:]))
+	}))
+	defer srv.Close()
+
+	d, _ := newTestDownloader(2)
+	dest := filepath.Join(t.TempDir(), "archive.zip")
+	if err := d.downloadFile(srv.URL, dest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=8-" {
+		t.Fatalf("expected a ranged retry from byte 8, got %q", ranges)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil || string(data) != payload {
+		t.Fatalf("unexpected downloaded content %q (err=%v)", data, err)
+	}
+}
+
+func TestDownloadFile_RestartsWithoutAcceptRanges(t *testing.T) {
+	const payload = "0123456789abcdefghij"
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("hijack: %v", err)
+				return
+			}
+			fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(payload), payload[:8])
+			buf.Flush()
+			conn.Close()
+			return
+		}
+		w.Write([]byte(payload))
+	}))
+	defer srv.Close()
+
+	d, _ := newTestDownloader(2)
+	dest := filepath.Join(t.TempDir(), "archive.zip")
+	if err := d.downloadFile(srv.URL, dest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ranges) != 2 || ranges[1] != "" {
+		t.Fatalf("expected a plain retry, got %q", ranges)
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil || string(data) != payload {
+		t.Fatalf("unexpected downloaded content %q (err=%v)", data, err)
+	}
+}
+
+func TestContentRangeStart(t *testing.T) {
+	if start, ok := contentRangeStart("bytes 100-199/200"); !ok || start != 100 {
+		t.Fatalf("expected 100, got %d (ok=%v)", start, ok)
+	}
+	if _, ok := contentRangeStart("bytes */200"); ok {
+		t.Fatalf("expected unsatisfied range to be rejected")
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	_, malformed := http.Get("http://[::1")
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &httpStatusError{StatusCode: http.StatusBadGateway}, true},
+		{"rate limited", &httpStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"not found", &httpStatusError{StatusCode: http.StatusNotFound}, false},
+		{"truncated body", fmt.Errorf("copy: %w", io.ErrUnexpectedEOF), true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{"malformed url", malformed, false},
+		{"unknown certificate", &url.Error{Op: "Get", URL: "https://example.com", Err: x509.UnknownAuthorityError{}}, false},
+		{"local file", &fs.PathError{Op: "open", Path: "x", Err: fs.ErrNotExist}, false},
+	}
+	for _, tc := range cases {
+		if got := retryable(tc.err); got != tc.want {
+			t.Fatalf("%s: retryable(%v) = %v, want %v", tc.name, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestDownloadFile_FailsOnLongRetryAfter(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "86400")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	d, sleeps := newTestDownloader(2)
+	err := d.downloadFile(srv.URL, filepath.Join(t.TempDir(), "archive.zip"))
+	if err == nil || !strings.Contains(err.Error(), "retry after") {
+		t.Fatalf("expected a Retry-After error, got %v", err)
+	}
+	if calls != 1 || len(*sleeps) != 0 {
+		t.Fatalf("expected a single attempt without sleeping, got %d calls and sleeps %v", calls, *sleeps)
+	}
+}
+
+func TestFetchChecksum_Retries(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprintln(w, "ABC123  flow2apex_linux_amd64_v0.2.0.zip")
+	}))
+	defer srv.Close()
+
+	d, _ := newTestDownloader(2)
+	got, err := fetchChecksum(d, srv.URL, "flow2apex_linux_amd64_v0.2.0.zip", filepath.Join(t.TempDir(), "checksums.txt"))
+	if err != nil || got != "abc123" {
+		t.Fatalf("unexpected checksum %q (err=%v)", got, err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 attempts, got %d", calls)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := parseRetryAfter("3", now); got != 3*time.Second {
+		t.Fatalf("expected 3s, got %v", got)
+	}
+	if got := parseRetryAfter(now.Add(5*time.Second).Format(http.TimeFormat), now); got != 5*time.Second {
+		t.Fatalf("expected 5s from HTTP date, got %v", got)
+	}
+	if got := parseRetryAfter("soon", now); got != 0 {
+		t.Fatalf("expected 0 for invalid value, got %v", got)
+	}
+}
+
+func TestDownloadFile_DoesNotRetryLocalErrors(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("archive"))
+	}))
+	defer srv.Close()
+
+	d, sleeps := newTestDownloader(2)
+	dest := filepath.Join(t.TempDir(), "missing", "archive.zip")
+	if err := d.downloadFile(srv.URL, dest); err == nil {
+		t.Fatalf("expected error writing into a missing directory")
+	}
+	if calls != 1 || len(*sleeps) != 0 {
+		t.Fatalf("expected a single attempt, got %d calls and sleeps %v", calls, *sleeps)
+	}
+}
+
+func TestVersionOutputMatches(t *testing.T) {
+	cases := []struct {
+		output  string
+		version string
+		want    bool
+	}{
+		{"flow2apex version v0.2.0\n", "v0.2.0", true},
+		{"flow2apex version 0.2.0\n", "v0.2.0", true},
+		{"flow2apex version v0.2.0-3-gabc123\n", "v0.2.0", false},
+		{"flow2apex version v0.1.0\n", "v0.2.0", false},
+		{"", "v0.2.0", false},
+	}
+	for _, tc := range cases {
+		if got := versionOutputMatches(tc.output, tc.version); got != tc.want {
+			t.Fatalf("versionOutputMatches(%q, %q) = %v, want %v", tc.output, tc.version, got, tc.want)
+		}
+	}
+}
+
+func TestVerifyBinary(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good")
+	if err := os.WriteFile(good, []byte("#!/bin/sh\necho flow2apex version v0.2.0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyBinary(good, "linux", "amd64"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bad := filepath.Join(dir, "bad")
+	if err := os.WriteFile(bad, []byte{0x7f, 'E', 'L', 'F', 0, 0, 0, 0}, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := verifyBinary(bad, "linux", "arm64")
+	if err == nil {
+		t.Fatalf("expected error for a binary that cannot run")
+	}
+	if !strings.Contains(err.Error(), "linux_arm64") || !strings.Contains(err.Error(), "--skip-verify") {
+		t.Fatalf("expected platform and escape hatch in error, got %v", err)
+	}
+}
+
+func TestExplainDownloadError_WindowsArm64NotFound(t *testing.T) {
+	notFound := &httpStatusError{URL: "u", Status: "404 Not Found", StatusCode: http.StatusNotFound}
+
+	err := explainDownloadError(fmt.Errorf("download archive: %w", notFound), "windows", "arm64")
+	if !strings.Contains(err.Error(), "--runner-arch amd64") {
+		t.Fatalf("expected amd64 emulation hint, got %v", err)
+	}
+	if err := explainDownloadError(notFound, "linux", "arm64"); strings.Contains(err.Error(), "emulation") {
+		t.Fatalf("expected no hint for linux arm64, got %v", err)
+	}
+	serverErr := &httpStatusError{URL: "u", Status: "502 Bad Gateway", StatusCode: http.StatusBadGateway}
+	if err := explainDownloadError(serverErr, "windows", "arm64"); strings.Contains(err.Error(), "emulation") {
+		t.Fatalf("expected no hint for non-404 errors, got %v", err)
+	}
+}
+
+func TestNormalizeBinaryName(t *testing.T) {
+	cases := []struct {
+		name     string
+		platform string
+		want     string
+	}{
+		{"", "linux", "flow2apex"},
+		{"", "windows", "flow2apex.exe"},
+		{"flow2apex-1.2.0", "darwin", "flow2apex-1.2.0"},
+		{"flow2apex-1.2.0", "windows", "flow2apex-1.2.0.exe"},
+		{"f2a.EXE", "windows", "f2a.EXE"},
+	}
+	for _, tc := range cases {
+		got, err := normalizeBinaryName(tc.name, tc.platform)
+		if err != nil || got != tc.want {
+			t.Fatalf("normalizeBinaryName(%q, %q) = %q, %v; want %q", tc.name, tc.platform, got, err, tc.want)
+		}
+	}
+	for _, name := range []string{"bin/flow2apex", `bin\flow2apex`, ".."} {
+		if _, err := normalizeBinaryName(name, "linux"); err == nil {
+			t.Fatalf("expected error for %q", name)
+		}
+	}
+}
+
+func TestReleaseAssetURL(t *testing.T) {
+	got := releaseAssetURL("", "octoberswimmer/flow2apex", "v0.2.0", "flow2apex_linux_amd64_v0.2.0.zip")
+	if got != "https://github.com/octoberswimmer/flow2apex/releases/download/v0.2.0/flow2apex_linux_amd64_v0.2.0.zip" {
+		t.Fatalf("unexpected default URL: %s", got)
+	}
+	got = releaseAssetURL("https://mirror.example.com/github/", "octoberswimmer/flow2apex", "v0.2.0", "a.zip")
+	if got != "https://mirror.example.com/github/octoberswimmer/flow2apex/releases/download/v0.2.0/a.zip" {
+		t.Fatalf("unexpected mirrored URL: %s", got)
+	}
+}
+
+type archiveEntry struct {
+	name string
+	body string
+}
+
+func writeTestZip(t *testing.T, path string, entries []archiveEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestTarGz(t *testing.T, path string, entries []archiveEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0o755, Size: int64(len(e.body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.body))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractFlow2ApexBinary_EntrySelection(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []archiveEntry
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "exact name preferred over helper",
+			entries: []archiveEntry{{"dist/flow2apex-helper", "helper"}, {"dist/flow2apex", "binary"}, {"README.md", "readme"}},
+			want:    "binary",
+		},
+		{
+			name:    "single prefixed entry",
+			entries: []archiveEntry{{"flow2apex_linux_amd64", "binary"}, {"LICENSE", "license"}},
+			want:    "binary",
+		},
+		{
+			name:    "ambiguous prefixed entries",
+			entries: []archiveEntry{{"flow2apex-helper", "helper"}, {"flow2apex-cli", "cli"}},
+			wantErr: `start with it: "flow2apex-helper", "flow2apex-cli"`,
+		},
+		{
+			name:    "ambiguous exact entries",
+			entries: []archiveEntry{{"a/flow2apex", "a"}, {"b/flow2apex", "b"}},
+			wantErr: `named flow2apex: "a/flow2apex", "b/flow2apex"`,
+		},
+		{
+			name:    "missing",
+			entries: []archiveEntry{{"README.md", "readme"}},
+			wantErr: "not found",
+		},
+	}
+	for _, tc := range cases {
+		for _, ext := range []string{"zip", "tar.gz"} {
+			t.Run(tc.name+" "+ext, func(t *testing.T) {
+				dir := t.TempDir()
+				archivePath := filepath.Join(dir, "flow2apex_linux_amd64_v0.2.0."+ext)
+				if ext == "zip" {
+					writeTestZip(t, archivePath, tc.entries)
+				} else {
+					writeTestTarGz(t, archivePath, tc.entries)
+				}
+				extracted, err := extractFlow2ApexBinary(archivePath, filepath.Join(dir, "out"))
+				if tc.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+						t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				data, err := os.ReadFile(extracted)
+				if err != nil || string(data) != tc.want {
+					t.Fatalf("extracted %q (err=%v), want %q", data, err, tc.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Package release looks up flow2apex releases through the GitHub API.
+package release
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// apiBaseURL is the GitHub REST API root. Tests point it at a local server.
+var apiBaseURL = "https://api.github.com"
+
+// StatusError reports an unsuccessful GitHub API response.
+type StatusError struct {
+	Status     string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %s", e.Status)
+}
+
+// LatestTag returns the tag of the newest published release in repo. Without
+// includePrereleases it asks for the repository's latest release, which skips
+// drafts and prereleases, and falls back to the newest stable release in the
+// release list when that endpoint has nothing. With includePrereleases it
+// returns the newest non-draft release.
+func LatestTag(client *http.Client, repo string, includePrereleases bool) (string, error) {
+	if includePrereleases {
+		return latestFromList(client, repo, true)
+	}
+	return latestRelease(client, repo, false)
+}
+
+// LatestTagOrPrerelease returns the repository's latest release like
+// LatestTag without includePrereleases, but when that endpoint has nothing it
+// falls back to the newest non-draft release even if it is a prerelease, so
+// repositories that only publish prereleases still resolve.
+func LatestTagOrPrerelease(client *http.Client, repo string) (string, error) {
+	return latestRelease(client, repo, true)
+}
+
+func latestRelease(client *http.Client, repo string, fallbackPrereleases bool) (string, error) {
+	url := fmt.Sprintf("%s/repos/%s/releases/latest", apiBaseURL, repo)
+	resp, err := githubGet(client, url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return latestFromList(client, repo, fallbackPrereleases)
+	}
+	if resp.StatusCode >= 400 {
+		return "", &StatusError{Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+
+	var payload struct {
+		Tag string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return "", err
+	}
+	if payload.Tag == "" {
+		return "", fmt.Errorf("latest release response missing tag_name")
+	}
+	return payload.Tag, nil
+}
+
+func latestFromList(client *http.Client, repo string, includePrereleases bool) (string, error) {
+	url := fmt.Sprintf("%s/repos/%s/releases?per_page=30", apiBaseURL, repo)
+	resp, err := githubGet(client, url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return "", &StatusError{Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+
+	var releases []struct {
+		Tag        string `json:"tag_name"`
+		Draft      bool   `json:"draft"`
+		Prerelease bool   `json:"prerelease"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return "", err
+	}
+	for _, rel := range releases {
+		if rel.Draft || (rel.Prerelease && !includePrereleases) {
+			continue
+		}
+		if rel.Tag != "" {
+			return rel.Tag, nil
+		}
+	}
+	if !includePrereleases {
+		return "", fmt.Errorf("no stable release found in %s; pin --version to a release tag", repo)
+	}
+	return "", fmt.Errorf("no published releases found in %s", repo)
+}
+
+func githubGet(client *http.Client, url string) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := strings.TrimSpace(os.Getenv("GITHUB_TOKEN")); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,88 @@
+package release
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func withAPI(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	prev := apiBaseURL
+	apiBaseURL = srv.URL
+	t.Cleanup(func() { apiBaseURL = prev })
+}
+
+func TestLatestTag_UsesLatestEndpoint(t *testing.T) {
+	withAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/o/r/releases/latest" {
+			t.Errorf("unexpected request path %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"tag_name":"v1.2.0"}`))
+	})
+
+	tag, err := LatestTag(nil, "o/r", false)
+	if err != nil || tag != "v1.2.0" {
+		t.Fatalf("expected v1.2.0, got %q (err=%v)", tag, err)
+	}
+}
+
+func TestLatestTag_IncludePrereleases(t *testing.T) {
+	withAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/o/r/releases" {
+			t.Errorf("unexpected request path %s", r.URL.Path)
+		}
+		w.Write([]byte(`[{"tag_name":"v2.0.0-draft","draft":true},{"tag_name":"v2.0.0-rc.1","prerelease":true},{"tag_name":"v1.2.0"}]`))
+	})
+
+	tag, err := LatestTag(nil, "o/r", true)
+	if err != nil || tag != "v2.0.0-rc.1" {
+		t.Fatalf("expected v2.0.0-rc.1, got %q (err=%v)", tag, err)
+	}
+}
+
+func TestLatestTag_StatusError(t *testing.T) {
+	withAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	_, err := LatestTag(nil, "o/r", false)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 status error, got %v", err)
+	}
+}
+
+func TestLatestTag_FallbackSkipsPrereleases(t *testing.T) {
+	withAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/o/r/releases/latest" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`[{"tag_name":"v2.0.0-rc.1","prerelease":true}]`))
+	})
+
+	tag, err := LatestTag(nil, "o/r", false)
+	if err == nil || !strings.Contains(err.Error(), "pin --version") {
+		t.Fatalf("expected a no stable release error, got %q (err=%v)", tag, err)
+	}
+}
+
+func TestLatestTagOrPrerelease_FallsBackToPrerelease(t *testing.T) {
+	withAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/o/r/releases/latest" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`[{"tag_name":"v2.0.0-draft","draft":true},{"tag_name":"v2.0.0-rc.1","prerelease":true}]`))
+	})
+
+	tag, err := LatestTagOrPrerelease(nil, "o/r")
+	if err != nil || tag != "v2.0.0-rc.1" {
+		t.Fatalf("expected v2.0.0-rc.1, got %q (err=%v)", tag, err)
+	}
+}
@@ -0,0 +1,164 @@
+package sidebyside
+
+import "fmt"
+
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+// edit is one step of a line edit script. a indexes the left input for
+// opEqual/opDelete and b indexes the right input for opEqual/opInsert.
+type edit struct {
+	kind opKind
+	a    int
+	b    int
+}
+
+// diffLines computes a shortest edit script between a and b using the
+// linear-space variant of Myers' O(ND) algorithm, which splits the problem at
+// the middle snake of each range instead of keeping every round's frontier.
+func diffLines(a, b []string) ([]edit, error) {
+	// Compare small integers rather than strings in the inner loops.
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+
+	// Each search runs at most half the combined length deep.
+	limit := (len(a) + len(b) + 1) / 2
+	d := &differ{
+		a:  intern(a),
+		b:  intern(b),
+		vf: make([]int, 2*limit+3),
+		vb: make([]int, 2*limit+3),
+	}
+	if err := d.compare(0, len(a), 0, len(b)); err != nil {
+		return nil, err
+	}
+	return d.edits, nil
+}
+
+// differ holds the inputs, the two frontier arrays shared by every
+// middle-snake search, and the edit script built so far.
+type differ struct {
+	a, b   []int
+	vf, vb []int
+	edits  []edit
+}
+
+// compare appends the edit script for a[aLo:aHi] against b[bLo:bHi].
+func (d *differ) compare(aLo, aHi, bLo, bHi int) error {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.edits = append(d.edits, edit{kind: opEqual, a: aLo, b: bLo})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-suffix-1] == d.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+
+	switch {
+	case aLo == aHi:
+		for ; bLo < bHi; bLo++ {
+			d.edits = append(d.edits, edit{kind: opInsert, a: aLo, b: bLo})
+		}
+	case bLo == bHi:
+		for ; aLo < aHi; aLo++ {
+			d.edits = append(d.edits, edit{kind: opDelete, a: aLo, b: bLo})
+		}
+	default:
+		x, y, u, v, ok := d.middleSnake(aLo, aHi, bLo, bHi)
+		if !ok {
+			return fmt.Errorf("sidebyside: no middle snake for lines %d-%d against %d-%d", aLo+1, aHi, bLo+1, bHi)
+		}
+		if err := d.compare(aLo, x, bLo, y); err != nil {
+			return err
+		}
+		for ; x < u; x, y = x+1, y+1 {
+			d.edits = append(d.edits, edit{kind: opEqual, a: x, b: y})
+		}
+		if err := d.compare(u, aHi, v, bHi); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		d.edits = append(d.edits, edit{kind: opEqual, a: aHi + i, b: bHi + i})
+	}
+	return nil
+}
+
+// middleSnake finds the diagonal run (x, y)-(u, v) in the middle of a
+// shortest edit path from (aLo, bLo) to (aHi, bHi) by searching forward from
+// the start and backward from the end until the two frontiers overlap. Both
+// ranges must be non-empty and differ in their first and last lines. The
+// frontiers always meet by depth limit; ok is false only if they somehow
+// don't, so the caller can report an error instead of crashing the run.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int, ok bool) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta&1 != 0
+	limit := (n + m + 1) / 2
+	// vf[off+k] is the furthest x on forward diagonal k; vb[off+k] is the
+	// furthest distance back from the end on reverse diagonal k, which is
+	// forward diagonal delta-k.
+	off := limit + 1
+	vf, vb := d.vf[:2*limit+3], d.vb[:2*limit+3]
+	vf[off+1], vb[off+1] = 0, 0
+
+	for depth := 0; depth <= limit; depth++ {
+		for k := -depth; k <= depth; k += 2 {
+			var fx int
+			if k == -depth || (k != depth && vf[off+k-1] < vf[off+k+1]) {
+				fx = vf[off+k+1]
+			} else {
+				fx = vf[off+k-1] + 1
+			}
+			fy := fx - k
+			sx, sy := fx, fy
+			for fx < n && fy < m && d.a[aLo+fx] == d.b[bLo+fy] {
+				fx++
+				fy++
+			}
+			vf[off+k] = fx
+			if rk := delta - k; odd && rk >= -(depth-1) && rk <= depth-1 && fx+vb[off+rk] >= n {
+				return aLo + sx, bLo + sy, aLo + fx, bLo + fy, true
+			}
+		}
+		for k := -depth; k <= depth; k += 2 {
+			var rx int
+			if k == -depth || (k != depth && vb[off+k-1] < vb[off+k+1]) {
+				rx = vb[off+k+1]
+			} else {
+				rx = vb[off+k-1] + 1
+			}
+			ry := rx - k
+			sx, sy := rx, ry
+			for rx < n && ry < m && d.a[aHi-rx-1] == d.b[bHi-ry-1] {
+				rx++
+				ry++
+			}
+			vb[off+k] = rx
+			if fk := delta - k; !odd && fk >= -depth && fk <= depth && vf[off+fk]+rx >= n {
+				return aHi - rx, bHi - ry, aHi - sx, bHi - sy, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
@@ -0,0 +1,266 @@
+// Package sidebyside renders column-aligned side-by-side diffs of two file
+// trees without depending on a system diff binary. The output mirrors the
+// layout of GNU diff --recursive --side-by-side --new-file --expand-tabs so it
+// can be post-processed the same way.
+package sidebyside
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// gutterWidth is GNU diff's minimum gap between the two columns.
+const gutterWidth = 3
+
+// Options controls the rendered output.
+type Options struct {
+	// Width is the total output width in columns.
+	Width int
+	// TabSize is the tab stop width used when expanding tabs.
+	TabSize int
+}
+
+// Layout describes where each part of a side-by-side line is placed.
+type Layout struct {
+	// HalfWidth is the maximum number of columns shown for each side.
+	HalfWidth int
+	// Marker is the zero-based column of the change marker.
+	Marker int
+	// RightOffset is the zero-based column where the right side starts.
+	RightOffset int
+}
+
+// NewLayout computes the column layout GNU diff uses for the given width with
+// tabs expanded.
+func NewLayout(width int) Layout {
+	offset := (width + 1 + gutterWidth) / 2
+	halfWidth := offset - gutterWidth
+	if width-offset < halfWidth {
+		halfWidth = width - offset
+	}
+	if halfWidth < 0 {
+		halfWidth = 0
+	}
+	rightOffset := offset
+	if halfWidth == 0 {
+		rightOffset = width
+	}
+	marker := (halfWidth + rightOffset - 1) / 2
+	if marker < 0 {
+		marker = 0
+	}
+	return Layout{HalfWidth: halfWidth, Marker: marker, RightOffset: rightOffset}
+}
+
+// DiffTrees compares every regular file under baseDir and headDir. Files that
+// exist on only one side are compared against an empty file. Like GNU diff in
+// side-by-side mode, every compared file is rendered in full and introduced by
+// a "diff -- <base> <head>" header. The returned bool reports whether any
+// differences were found.
+func DiffTrees(baseDir, headDir string, opts Options) (bool, string, error) {
+	baseFiles, err := listFiles(baseDir)
+	if err != nil {
+		return false, "", err
+	}
+	headFiles, err := listFiles(headDir)
+	if err != nil {
+		return false, "", err
+	}
+
+	seen := make(map[string]bool, len(baseFiles)+len(headFiles))
+	var rels []string
+	for _, rel := range append(baseFiles, headFiles...) {
+		if !seen[rel] {
+			seen[rel] = true
+			rels = append(rels, rel)
+		}
+	}
+	sort.Strings(rels)
+
+	var out strings.Builder
+	changed := false
+	for _, rel := range rels {
+		basePath := filepath.Join(baseDir, filepath.FromSlash(rel))
+		headPath := filepath.Join(headDir, filepath.FromSlash(rel))
+		left, err := readOptional(basePath)
+		if err != nil {
+			return false, "", err
+		}
+		right, err := readOptional(headPath)
+		if err != nil {
+			return false, "", err
+		}
+		if !bytes.Equal(left, right) {
+			changed = true
+		}
+
+		if out.Len() > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString("diff -- " + basePath + " " + headPath + "\n")
+		if isBinary(left) || isBinary(right) {
+			if !bytes.Equal(left, right) {
+				out.WriteString(fmt.Sprintf("Binary files %s and %s differ\n", basePath, headPath))
+			}
+			continue
+		}
+		text, err := DiffLines(splitLines(left), splitLines(right), opts)
+		if err != nil {
+			return false, "", fmt.Errorf("diff %s: %w", rel, err)
+		}
+		out.WriteString(text)
+	}
+	return changed, out.String(), nil
+}
+
+// DiffLines renders every line of left and right in side-by-side form,
+// marking changed pairs with '|', deletions with '<' and insertions with '>'.
+func DiffLines(left, right []string, opts Options) (string, error) {
+	layout := NewLayout(opts.Width)
+	tabSize := opts.TabSize
+	if tabSize <= 0 {
+		tabSize = 8
+	}
+
+	var out strings.Builder
+	edits, err := diffLines(left, right)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(edits); {
+		if edits[i].kind == opEqual {
+			writeLine(&out, layout, tabSize, left[edits[i].a], true, ' ', right[edits[i].b], true)
+			i++
+			continue
+		}
+
+		var deleted, inserted []string
+		for ; i < len(edits) && edits[i].kind != opEqual; i++ {
+			if edits[i].kind == opDelete {
+				deleted = append(deleted, left[edits[i].a])
+			} else {
+				inserted = append(inserted, right[edits[i].b])
+			}
+		}
+		for j := 0; j < len(deleted) || j < len(inserted); j++ {
+			switch {
+			case j < len(deleted) && j < len(inserted):
+				writeLine(&out, layout, tabSize, deleted[j], true, '|', inserted[j], true)
+			case j < len(deleted):
+				writeLine(&out, layout, tabSize, deleted[j], true, '<', "", false)
+			default:
+				writeLine(&out, layout, tabSize, "", false, '>', inserted[j], true)
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+func writeLine(out *strings.Builder, layout Layout, tabSize int, left string, hasLeft bool, sep byte, right string, hasRight bool) {
+	col := 0
+	if hasLeft {
+		col = writeHalfLine(out, left, 0, layout.HalfWidth, tabSize)
+	}
+	if sep != ' ' {
+		col = padTo(out, col, layout.Marker) + 1
+		out.WriteByte(sep)
+	}
+	if hasRight && right != "" {
+		col = padTo(out, col, layout.RightOffset)
+		writeHalfLine(out, right, col, layout.HalfWidth, tabSize)
+	}
+	out.WriteByte('\n')
+}
+
+// writeHalfLine writes line starting at column indent, expanding tabs and
+// truncating at halfWidth columns. It returns the column reached.
+func writeHalfLine(out *strings.Builder, line string, indent, halfWidth, tabSize int) int {
+	bound := indent + halfWidth
+	in := indent
+	pos := indent
+	for _, r := range line {
+		switch r {
+		case '\t':
+			spaces := tabSize - in%tabSize
+			if in == pos {
+				stop := pos + spaces
+				if stop > bound {
+					stop = bound
+				}
+				for ; pos < stop; pos++ {
+					out.WriteByte(' ')
+				}
+			}
+			in += spaces
+		case '\r':
+			continue
+		default:
+			if in < bound {
+				pos = in + 1
+				out.WriteRune(r)
+			}
+			in++
+		}
+	}
+	return pos
+}
+
+func padTo(out *strings.Builder, from, to int) int {
+	for ; from < to; from++ {
+		out.WriteByte(' ')
+	}
+	return to
+}
+
+func listFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list files in %s: %w", root, err)
+	}
+	return files, nil
+}
+
+func readOptional(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	text := strings.TrimSuffix(string(data), "\n")
+	return strings.Split(text, "\n")
+}
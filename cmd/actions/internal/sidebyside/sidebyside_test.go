@@ -0,0 +1,235 @@
+package sidebyside
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewLayout(t *testing.T) {
+	// Expected markers were captured from GNU diff --side-by-side --expand-tabs.
+	cases := map[int]int{
+		80:  39,
+		130: 64,
+		131: 65,
+		200: 99,
+	}
+	for width, want := range cases {
+		if got := NewLayout(width).Marker; got != want {
+			t.Fatalf("NewLayout(%d).Marker = %d, want %d", width, got, want)
+		}
+	}
+}
+
+// Expected DiffLines output was captured from GNU diff -y -W 20 -t --tabsize=3.
+func TestDiffLines(t *testing.T) {
+	left := []string{"same", "old", "gone"}
+	right := []string{"same", "new", "extra", "added"}
+
+	got, err := DiffLines(left, right, Options{Width: 20, TabSize: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := strings.Join([]string{
+		"same        same",
+		"old      |  new",
+		"gone     |  extra",
+		"         >  added",
+		"",
+	}, "\n")
+	if got != want {
+		t.Fatalf("unexpected output:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestDiffLines_DeletionAndTabs(t *testing.T) {
+	left := []string{"\tkeep", "drop"}
+	right := []string{"\tkeep"}
+
+	got, err := DiffLines(left, right, Options{Width: 20, TabSize: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "   keep        keep\ndrop     <\n"
+	if got != want {
+		t.Fatalf("unexpected output:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestDiffLines_TruncatesLongLines(t *testing.T) {
+	long := strings.Repeat("x", 40)
+	got, err := DiffLines([]string{long}, []string{"y"}, Options{Width: 20, TabSize: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	layout := NewLayout(20)
+	line := strings.TrimSuffix(got, "\n")
+	if line[layout.Marker] != '|' {
+		t.Fatalf("expected marker at column %d, got %q", layout.Marker, line)
+	}
+	if !strings.HasPrefix(line, strings.Repeat("x", layout.HalfWidth)+" ") {
+		t.Fatalf("expected left side truncated to %d columns, got %q", layout.HalfWidth, line)
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	base := t.TempDir()
+	head := t.TempDir()
+	writeFile(t, filepath.Join(base, "Same.cls"), "same\n")
+	writeFile(t, filepath.Join(head, "Same.cls"), "same\n")
+	writeFile(t, filepath.Join(base, "sub", "Gone.cls"), "gone\n")
+	writeFile(t, filepath.Join(head, "New.cls"), "new\n")
+
+	changed, got, err := DiffTrees(base, head, Options{Width: 20, TabSize: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected differences to be reported")
+	}
+	headers := []string{
+		"diff -- " + filepath.Join(base, "New.cls") + " " + filepath.Join(head, "New.cls"),
+		"diff -- " + filepath.Join(base, "Same.cls") + " " + filepath.Join(head, "Same.cls"),
+		"diff -- " + filepath.Join(base, "sub", "Gone.cls") + " " + filepath.Join(head, "sub", "Gone.cls"),
+	}
+	last := -1
+	for _, header := range headers {
+		idx := strings.Index(got, header)
+		if idx < 0 {
+			t.Fatalf("expected header %q in output:\n%s", header, got)
+		}
+		if idx < last {
+			t.Fatalf("expected headers in sorted order:\n%s", got)
+		}
+		last = idx
+	}
+	if !strings.Contains(got, "         >  new\n") || !strings.Contains(got, "gone     <\n") {
+		t.Fatalf("expected added and removed files to be diffed against empty files:\n%s", got)
+	}
+}
+
+func TestDiffTrees_NoChanges(t *testing.T) {
+	base := t.TempDir()
+	head := t.TempDir()
+	writeFile(t, filepath.Join(base, "Same.cls"), "same\n")
+	writeFile(t, filepath.Join(head, "Same.cls"), "same\n")
+
+	changed, _, err := DiffTrees(base, head, Options{Width: 20, TabSize: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed {
+		t.Fatalf("expected identical trees to report no differences")
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+}
+
+func TestDiffLines_LargeRewrite(t *testing.T) {
+	// Every line differs, so the edit distance is the combined length; the
+	// differ must handle it without quadratic memory.
+	const n = 5000
+	left := make([]string, n)
+	right := make([]string, n)
+	for i := range left {
+		left[i] = fmt.Sprintf("old %d", i)
+		right[i] = fmt.Sprintf("new %d", i)
+	}
+
+	edits, err := diffLines(left, right)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(edits) != 2*n {
+		t.Fatalf("expected %d edits, got %d", 2*n, len(edits))
+	}
+	for _, e := range edits {
+		if e.kind == opEqual {
+			t.Fatalf("unexpected equal edit %+v", e)
+		}
+	}
+	got, err := DiffLines(left, right, Options{Width: 40})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lines := strings.Count(got, "\n"); lines != n {
+		t.Fatalf("expected %d output lines, got %d", n, lines)
+	}
+}
+
+func TestDiffLines_RandomMatchesLCS(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	alphabet := []string{"a", "b", "c", "d"}
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(12))
+		for i := range lines {
+			lines[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		return lines
+	}
+
+	for round := 0; round < 2000; round++ {
+		a, b := randomLines(), randomLines()
+		edits, err := diffLines(a, b)
+		if err != nil {
+			t.Fatalf("diffLines(%q, %q): %v", a, b, err)
+		}
+
+		var left, right []string
+		changes := 0
+		for _, e := range edits {
+			switch e.kind {
+			case opEqual:
+				if a[e.a] != b[e.b] {
+					t.Fatalf("diffLines(%q, %q): equal edit %+v pairs different lines", a, b, e)
+				}
+				left = append(left, a[e.a])
+				right = append(right, b[e.b])
+			case opDelete:
+				left = append(left, a[e.a])
+				changes++
+			case opInsert:
+				right = append(right, b[e.b])
+				changes++
+			}
+		}
+		if strings.Join(left, ",") != strings.Join(a, ",") || strings.Join(right, ",") != strings.Join(b, ",") {
+			t.Fatalf("diffLines(%q, %q): edits rebuild %q and %q", a, b, left, right)
+		}
+		if want := len(a) + len(b) - 2*lcsLength(a, b); changes != want {
+			t.Fatalf("diffLines(%q, %q): %d deletions and insertions, want %d", a, b, changes, want)
+		}
+	}
+}
+
+// lcsLength is the textbook dynamic-programming longest common subsequence.
+func lcsLength(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				dp[i][j] = dp[i+1][j+1] + 1
+			case dp[i+1][j] > dp[i][j+1]:
+				dp[i][j] = dp[i+1][j]
+			default:
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+	return dp[0][0]
+}
@@ -1,23 +1,27 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+
+	"github.com/octoberswimmer/flow2apex/actions/internal/release"
 )
 
 func main() {
 	var requested string
 	var repo string
 	var fallback string
+	var includePrereleases bool
 
 	flag.StringVar(&requested, "requested", "", "requested release tag (use 'latest' to resolve dynamically)")
 	flag.StringVar(&repo, "repo", "", "repository that hosts release assets")
 	flag.StringVar(&fallback, "fallback", "", "fallback repository if --repo is empty")
+	flag.BoolVar(&includePrereleases, "include-prereleases", envBool("FLOW2APEX_INCLUDE_PRERELEASES"), "resolve 'latest' to the newest release even when it is a prerelease")
 	flag.Parse()
 
 	repo = strings.TrimSpace(repo)
@@ -28,13 +32,9 @@ func main() {
 		log.Fatal("unable to determine repository that hosts release assets")
 	}
 
-	version := strings.TrimSpace(requested)
-	if version == "" || version == "latest" {
-		resolved, err := resolveLatestTag(repo)
-		if err != nil {
-			log.Fatalf("resolve latest release: %v", err)
-		}
-		version = resolved
+	version, err := resolveVersion(http.DefaultClient, requested, repo, includePrereleases)
+	if err != nil {
+		log.Fatalf("resolve latest release: %v", err)
 	}
 
 	output := os.Getenv("GITHUB_OUTPUT")
@@ -55,71 +55,22 @@ func main() {
 	fmt.Printf("Resolved release %q in repository %q\n", version, repo)
 }
 
-func resolveLatestTag(repo string) (string, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repo)
-	resp, err := githubGet(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNotFound {
-		return latestFromList(repo)
-	}
-	if resp.StatusCode >= 400 {
-		return "", fmt.Errorf("unexpected HTTP status %s", resp.Status)
-	}
-
-	var payload struct {
-		Tag string `json:"tag_name"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return "", err
-	}
-	if payload.Tag == "" {
-		return "", fmt.Errorf("latest release response missing tag_name")
-	}
-	return payload.Tag, nil
-}
-
-func latestFromList(repo string) (string, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/releases?per_page=1", repo)
-	resp, err := githubGet(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		return "", fmt.Errorf("unexpected HTTP status %s", resp.Status)
-	}
-
-	var releases []struct {
-		Tag   string `json:"tag_name"`
-		Draft bool   `json:"draft"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
-		return "", err
+// resolveVersion returns requested, or the repository's latest release tag
+// when requested is empty or "latest". Without includePrereleases, stable
+// releases win, but repositories that only publish prereleases resolve to
+// their newest prerelease.
+func resolveVersion(client *http.Client, requested, repo string, includePrereleases bool) (string, error) {
+	version := strings.TrimSpace(requested)
+	if version != "" && version != "latest" {
+		return version, nil
 	}
-	for _, rel := range releases {
-		if rel.Draft {
-			continue
-		}
-		if rel.Tag != "" {
-			return rel.Tag, nil
-		}
+	if includePrereleases {
+		return release.LatestTag(client, repo, true)
 	}
-	return "", fmt.Errorf("no published releases found")
+	return release.LatestTagOrPrerelease(client, repo)
 }
 
-func githubGet(url string) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Accept", "application/vnd.github+json")
-	if token := strings.TrimSpace(os.Getenv("GITHUB_TOKEN")); token != "" {
-		req.Header.Set("Authorization", "Bearer "+token)
-	}
-	return http.DefaultClient.Do(req)
+func envBool(name string) bool {
+	value, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(name)))
+	return err == nil && value
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// rewriteTransport sends every request to the test server instead of the
+// GitHub API.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (rt rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func testClient(t *testing.T, handler http.HandlerFunc) *http.Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("parse server url: %v", err)
+	}
+	return &http.Client{Transport: rewriteTransport{target: target}}
+}
+
+func TestResolveVersion_OnlyPrereleases(t *testing.T) {
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/o/r/releases/latest" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`[{"tag_name":"v0.3.0-rc.2","prerelease":true},{"tag_name":"v0.3.0-rc.1","prerelease":true}]`))
+	})
+
+	version, err := resolveVersion(client, "latest", "o/r", false)
+	if err != nil || version != "v0.3.0-rc.2" {
+		t.Fatalf("expected v0.3.0-rc.2, got %q (err=%v)", version, err)
+	}
+}
+
+func TestResolveVersion_PinnedSkipsLookup(t *testing.T) {
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+	})
+
+	version, err := resolveVersion(client, " v0.2.0 ", "o/r", false)
+	if err != nil || version != "v0.2.0" {
+		t.Fatalf("expected v0.2.0, got %q (err=%v)", version, err)
+	}
+}
+
+func TestResolveVersion_IncludePrereleases(t *testing.T) {
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/o/r/releases" {
+			t.Errorf("unexpected request path %s", r.URL.Path)
+		}
+		w.Write([]byte(`[{"tag_name":"v0.3.0-rc.1","prerelease":true},{"tag_name":"v0.2.0"}]`))
+	})
+
+	version, err := resolveVersion(client, "latest", "o/r", true)
+	if err != nil || version != "v0.3.0-rc.1" {
+		t.Fatalf("expected v0.3.0-rc.1, got %q (err=%v)", version, err)
+	}
+}